@@ -29,3 +29,26 @@ var RemoveUserError = newAppError("REMOVE_USER_ERROR", http.StatusInternalServer
 var SlackAuthBadRequest = newAppError("SLACK_AUTH_BAD_REQUEST", http.StatusBadRequest)
 var UserNotFound = newAppError("USER_NOT_FOUND", http.StatusNotFound)
 var UserAlreadyExists = newAppError("USER_ALREADY_EXISTS", http.StatusConflict)
+var UserDisabled = newAppError("USER_DISABLED", http.StatusConflict)
+var NothingPlaying = newAppError("NOTHING_PLAYING", http.StatusNotFound)
+var ShareTrackError = newAppError("SHARE_TRACK_ERROR", http.StatusInternalServerError)
+var ListeningEventNotFound = newAppError("LISTENING_EVENT_NOT_FOUND", http.StatusNotFound)
+var ListeningHistoryError = newAppError("LISTENING_HISTORY_ERROR", http.StatusInternalServerError)
+var DigestError = newAppError("DIGEST_ERROR", http.StatusInternalServerError)
+var InvalidDigestSchedule = newAppError("INVALID_DIGEST_SCHEDULE", http.StatusBadRequest)
+var WorkspaceNotFound = newAppError("WORKSPACE_NOT_FOUND", http.StatusNotFound)
+var TeamViewDisabled = newAppError("TEAM_VIEW_DISABLED", http.StatusForbidden)
+var TeamViewError = newAppError("TEAM_VIEW_ERROR", http.StatusInternalServerError)
+var WorkspaceSettingsError = newAppError("WORKSPACE_SETTINGS_ERROR", http.StatusInternalServerError)
+var ChartsError = newAppError("CHARTS_ERROR", http.StatusInternalServerError)
+var NotEnoughListeners = newAppError("NOT_ENOUGH_LISTENERS", http.StatusNotFound)
+var NotWorkspaceAdmin = newAppError("NOT_WORKSPACE_ADMIN", http.StatusForbidden)
+var PollScheduleError = newAppError("POLL_SCHEDULE_ERROR", http.StatusInternalServerError)
+var LeaseHeld = newAppError("LEASE_HELD", http.StatusConflict)
+var ExportError = newAppError("EXPORT_ERROR", http.StatusInternalServerError)
+var AuditError = newAppError("AUDIT_ERROR", http.StatusInternalServerError)
+var AdminUnauthorized = newAppError("ADMIN_UNAUTHORIZED", http.StatusUnauthorized)
+var AdminRouteNotFound = newAppError("ADMIN_ROUTE_NOT_FOUND", http.StatusNotFound)
+var AdminMethodNotAllowed = newAppError("ADMIN_METHOD_NOT_ALLOWED", http.StatusMethodNotAllowed)
+var InvalidAdminRequest = newAppError("INVALID_ADMIN_REQUEST", http.StatusBadRequest)
+var AdminError = newAppError("ADMIN_ERROR", http.StatusInternalServerError)
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/o-mago/spotify-status/src/app_error"
+	"github.com/o-mago/spotify-status/src/config"
+	"github.com/o-mago/spotify-status/src/crypto"
+	"github.com/o-mago/spotify-status/src/domain"
+	"github.com/o-mago/spotify-status/src/logging"
+	"github.com/o-mago/spotify-status/src/migrations"
+	"github.com/o-mago/spotify-status/src/repositories"
+	"github.com/o-mago/spotify-status/src/services"
+)
+
+// runCommand runs the operator commands working on users, on the same
+// layers as the server. They stop at the first SIGINT or SIGTERM.
+func runCommand(cfg config.Config, logger *slog.Logger, command string, args []string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	layers, err := newLayers(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer closeDatabase(layers.db)
+
+	// A newer binary doesn't move the schema ahead of the running servers
+	err = migrations.CheckUpToDate(layers.db)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "users":
+		return usersCommand(ctx, layers.services, args)
+	case "poll-once":
+		return pollOnce(ctx, layers.services, args)
+	case "rotate-keys":
+		return rotateKeys(ctx, layers.services, logger)
+	default:
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
+
+// checkConfig runs "config check", printing the configuration, secrets
+// redacted, and whatever is wrong with it. It returns the exit code.
+func checkConfig(cfg config.Config, args []string) int {
+	if len(args) != 1 || args[0] != "check" {
+		fmt.Fprintln(os.Stderr, "Usage: spotify-status config check")
+		return 2
+	}
+
+	fmt.Print(cfg.Redacted())
+
+	err := cfg.Validate()
+	if err != nil {
+		fmt.Printf("\nInvalid configuration:\n%s\n", err)
+		return 1
+	}
+
+	fmt.Println("\nConfiguration OK")
+	return 0
+}
+
+// usersCommand runs "users list [flags]" or "users disable <slack-id>"
+func usersCommand(ctx context.Context, svc services.Services, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: users list [flags] | users disable <slack-id>")
+	}
+
+	switch args[0] {
+	case "list":
+		return listUsers(ctx, svc, args[1:])
+	case "disable":
+		if len(args) != 2 {
+			return errors.New("usage: users disable <slack-id>")
+		}
+
+		err := svc.DisableUser(ctx, args[1])
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%s disabled\n", args[1])
+		return nil
+	default:
+		return fmt.Errorf("unknown users command %q, use list or disable", args[0])
+	}
+}
+
+// listUsers prints the users matching the flags as a table, going through
+// every page
+func listUsers(ctx context.Context, svc services.Services, args []string) error {
+	flags := flag.NewFlagSet("users list", flag.ContinueOnError)
+	workspace := flags.String("workspace", "", "only the users of this Slack team ID")
+	enabled := flags.String("enabled", "", "only the enabled (true) or disabled (false) users")
+	failing := flags.String("failing", "", "only the users whose last poll failed (true) or didn't (false)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	filter := domain.UserListFilter{SlackTeamID: *workspace}
+
+	var err error
+	filter.Enabled, err = domain.ParseUserListFlag(*enabled)
+	if err != nil {
+		return fmt.Errorf("-enabled: %w", err)
+	}
+	filter.Failing, err = domain.ParseUserListFlag(*failing)
+	if err != nil {
+		return fmt.Errorf("-failing: %w", err)
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "SLACK USER\tWORKSPACE\tENABLED\tLAST POLL\tOUTCOME\tERROR")
+
+	for {
+		users, err := svc.SearchUsersPage(ctx, filter)
+		if err != nil {
+			return err
+		}
+
+		for _, user := range users {
+			fmt.Fprintf(table, "%s\t%s\t%t\t%s\t%s\t%s\n", user.SlackUserID, user.SlackTeamID, user.Enabled,
+				formatTime(user.LastPollAt), user.LastPollOutcome, user.LastPollError)
+		}
+
+		if len(users) < services.MaxUsersPage {
+			break
+		}
+		filter.AfterSlackUserID = users[len(users)-1].SlackUserID
+	}
+
+	return table.Flush()
+}
+
+// pollOnce runs "poll-once --user <slack-id>", polling the user whatever
+// their schedule and printing the result
+func pollOnce(ctx context.Context, svc services.Services, args []string) error {
+	flags := flag.NewFlagSet("poll-once", flag.ContinueOnError)
+	slackUserID := flags.String("user", "", "the Slack user ID to poll")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *slackUserID == "" {
+		return errors.New("usage: poll-once --user <slack-id>")
+	}
+
+	user, err := svc.ResyncUser(ctx, *slackUserID)
+	if errors.Is(err, app_error.UserDisabled) {
+		return fmt.Errorf("%s disabled Spotify Status and isn't polled", *slackUserID)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s polled at %s: %s\n", user.SlackUserID, formatTime(user.LastPollAt), user.LastPollOutcome)
+	if user.LastPollError != "" {
+		fmt.Printf("error: %s\n", user.LastPollError)
+	}
+
+	return nil
+}
+
+// rotateKeys re-encrypts the stored tokens with the active key, so retired
+// keys can be removed from the ring
+func rotateKeys(ctx context.Context, svc services.Services, logger *slog.Logger) error {
+	rotated, err := svc.RotateKeys(ctx)
+	if err != nil {
+		logger.Error("rotating keys failed", "rotated", rotated, "error", err)
+		return err
+	}
+
+	logger.Info("rotated keys", "rotated", rotated)
+	return nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+// serveLocalTransit runs "transit-dev [address]", listening on :8200 by
+// default, like Vault
+func serveLocalTransit(cfg config.Config, args []string, logger *slog.Logger) error {
+	address := ":8200"
+	if len(args) > 0 {
+		address = args[0]
+	}
+
+	keys, err := cfg.CryptoKeyring()
+	if err != nil {
+		return err
+	}
+
+	transit, err := crypto.NewLocalTransit(keys, cfg.TransitToken)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("serving the local transit", "address", address, "keys", len(keys))
+	return http.ListenAndServe(address, logging.Middleware(logger, transit))
+}
+
+// migrate runs "migrate up", "migrate down [steps]" or "migrate status"
+func migrate(cfg config.Config, args []string, logger *slog.Logger) error {
+	db, err := repositories.OpenDatabase(cfg.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer closeDatabase(db)
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		return migrations.Up(db, logger)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil {
+				return err
+			}
+		}
+
+		return migrations.Down(db, steps, logger)
+	case "status":
+		statuses, err := migrations.Statuses(db)
+		if err != nil {
+			return err
+		}
+
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+
+			fmt.Printf("%04d %-50s %s\n", status.Version, status.Name, appliedAt)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q, use up, down [steps] or status", command)
+	}
+}
@@ -0,0 +1,435 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/o-mago/spotify-status/src/crypto"
+	"github.com/o-mago/spotify-status/src/domain"
+	"github.com/o-mago/spotify-status/src/logging"
+	"github.com/o-mago/spotify-status/src/telemetry"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting of the app. Each one is read from the optional
+// YAML file under its yaml key, then from its env variable, which wins.
+// Settings tagged secret are redacted when printed.
+type Config struct {
+	Port            string        `yaml:"port" env:"PORT"`
+	GracefulTimeout time.Duration `yaml:"graceful_timeout" env:"SPOTIFY_SLACK_APP_GRACEFUL_TIMEOUT"`
+
+	LogLevel  string `yaml:"log_level" env:"SPOTIFY_SLACK_APP_LOG_LEVEL"`
+	LogFormat string `yaml:"log_format" env:"SPOTIFY_SLACK_APP_LOG_FORMAT"`
+
+	DatabaseURL          string        `yaml:"database_url" env:"SPOTIFY_SLACK_APP_DATABASE_URL" secret:"true"`
+	DatabaseQueryTimeout time.Duration `yaml:"database_query_timeout" env:"SPOTIFY_SLACK_APP_DATABASE_QUERY_TIMEOUT"`
+
+	// CryptoProvider is env, file or transit. The env provider's keys are
+	// CryptoKeys, "id:key" entries, and CryptoKey under the ID "default".
+	// The file provider reads "id:key" lines from CryptoKeyFile. Either
+	// seals new values with CryptoActiveKey, else its last key. The transit
+	// provider seals them with data keys wrapped by the master key
+	// TransitKey, and keeps the env and file keys set to open older values.
+	CryptoProvider  string   `yaml:"crypto_provider" env:"SPOTIFY_SLACK_APP_CRYPTO_PROVIDER"`
+	CryptoKey       string   `yaml:"crypto_key" env:"SPOTIFY_SLACK_APP_CRYPTO_KEY" secret:"true"`
+	CryptoKeys      []string `yaml:"crypto_keys" env:"SPOTIFY_SLACK_APP_CRYPTO_KEYS" secret:"true"`
+	CryptoKeyFile   string   `yaml:"crypto_key_file" env:"SPOTIFY_SLACK_APP_CRYPTO_KEY_FILE"`
+	CryptoActiveKey string   `yaml:"crypto_active_key" env:"SPOTIFY_SLACK_APP_CRYPTO_ACTIVE_KEY"`
+	TransitAddress  string   `yaml:"transit_address" env:"SPOTIFY_SLACK_APP_TRANSIT_ADDRESS"`
+	TransitToken    string   `yaml:"transit_token" env:"SPOTIFY_SLACK_APP_TRANSIT_TOKEN" secret:"true"`
+	TransitMount    string   `yaml:"transit_mount" env:"SPOTIFY_SLACK_APP_TRANSIT_MOUNT"`
+	TransitKey      string   `yaml:"transit_key" env:"SPOTIFY_SLACK_APP_TRANSIT_KEY"`
+
+	SlackAuthURL       string `yaml:"slack_auth_url" env:"SPOTIFY_SLACK_APP_SLACK_AUTH_URL"`
+	SlackClientID      string `yaml:"slack_client_id" env:"SPOTIFY_SLACK_APP_SLACK_CLIENT_ID"`
+	SlackClientSecret  string `yaml:"slack_client_secret" env:"SPOTIFY_SLACK_APP_SLACK_CLIENT_SECRET" secret:"true"`
+	SlackSigningSecret string `yaml:"slack_signing_secret" env:"SPOTIFY_SLACK_APP_SIGNING_SECRET" secret:"true"`
+
+	SpotifyRedirectURL  string `yaml:"spotify_redirect_url" env:"SPOTIFY_SLACK_APP_SPOTIFY_REDIRECT_URL"`
+	SpotifyClientID     string `yaml:"spotify_client_id" env:"SPOTIFY_SLACK_APP_SPOTIFY_CLIENT_ID"`
+	SpotifyClientSecret string `yaml:"spotify_client_secret" env:"SPOTIFY_SLACK_APP_SPOTIFY_CLIENT_SECRET" secret:"true"`
+
+	// Telemetry is newrelic, otlp or none. When empty, New Relic is used if
+	// its license is set.
+	Telemetry       string `yaml:"telemetry" env:"SPOTIFY_SLACK_APP_TELEMETRY"`
+	NewRelicAppName string `yaml:"new_relic_app_name" env:"SPOTIFY_SLACK_APP_NEW_RELIC_APP_NAME"`
+	NewRelicLicense string `yaml:"new_relic_license" env:"SPOTIFY_SLACK_APP_NEW_RELIC_LICENSE" secret:"true"`
+	OTLPEndpoint    string `yaml:"otlp_endpoint" env:"SPOTIFY_SLACK_APP_OTLP_ENDPOINT"`
+	OTLPServiceName string `yaml:"otlp_service_name" env:"SPOTIFY_SLACK_APP_OTLP_SERVICE_NAME"`
+
+	PollInterval            time.Duration `yaml:"poll_interval" env:"SPOTIFY_SLACK_APP_POLL_INTERVAL"`
+	PollShards              int           `yaml:"poll_shards" env:"SPOTIFY_SLACK_APP_POLL_SHARDS"`
+	ReadyPollMaxAge         time.Duration `yaml:"ready_poll_max_age" env:"SPOTIFY_SLACK_APP_READY_POLL_MAX_AGE"`
+	LeaderLeaseTTL          time.Duration `yaml:"leader_lease_ttl" env:"SPOTIFY_SLACK_APP_LEADER_LEASE_TTL"`
+	ClearStatusesOnShutdown bool          `yaml:"clear_statuses_on_shutdown" env:"SPOTIFY_SLACK_APP_CLEAR_STATUSES_ON_SHUTDOWN"`
+
+	ListeningHistoryRetention time.Duration `yaml:"listening_history_retention" env:"SPOTIFY_SLACK_APP_LISTENING_HISTORY_RETENTION"`
+	AuditRetention            time.Duration `yaml:"audit_retention" env:"SPOTIFY_SLACK_APP_AUDIT_RETENTION"`
+	ChartsMinListeners        int           `yaml:"charts_min_listeners" env:"SPOTIFY_SLACK_APP_CHARTS_MIN_LISTENERS"`
+
+	// The admin API is served when operators can authenticate, with the
+	// bearer token AdminAPIToken or with the "user:password" basic auth
+	// credentials AdminAPIBasicAuth
+	AdminAPIToken     string `yaml:"admin_api_token" env:"SPOTIFY_SLACK_APP_ADMIN_API_TOKEN" secret:"true"`
+	AdminAPIBasicAuth string `yaml:"admin_api_basic_auth" env:"SPOTIFY_SLACK_APP_ADMIN_API_BASIC_AUTH" secret:"true"`
+}
+
+// Defaults returns the settings used when neither the file nor the env
+// provide them
+func Defaults() Config {
+	return Config{
+		Port:                      "8080",
+		GracefulTimeout:           15 * time.Second,
+		LogLevel:                  "info",
+		LogFormat:                 logging.FormatText,
+		CryptoProvider:            CryptoProviderEnv,
+		TransitMount:              "transit",
+		DatabaseQueryTimeout:      5 * time.Second,
+		PollInterval:              10 * time.Second,
+		OTLPServiceName:           "spotify-status",
+		PollShards:                1,
+		ReadyPollMaxAge:           time.Minute,
+		LeaderLeaseTTL:            30 * time.Second,
+		ListeningHistoryRetention: 90 * 24 * time.Hour,
+		AuditRetention:            2 * 365 * 24 * time.Hour,
+		// Charts need at least 3 listeners so nobody can be singled out
+		ChartsMinListeners: 3,
+	}
+}
+
+// Load reads the defaults, then the YAML file at path unless it's empty,
+// then the env
+func Load(path string) (Config, error) {
+	config := Defaults()
+
+	if path != "" {
+		file, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+
+		err = yaml.Unmarshal(file, &config)
+		if err != nil {
+			return Config{}, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	err := config.loadEnv(os.LookupEnv)
+	if err != nil {
+		return Config{}, err
+	}
+
+	return config, nil
+}
+
+func (c *Config) loadEnv(lookupEnv func(key string) (string, bool)) error {
+	value := reflect.ValueOf(c).Elem()
+
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+
+		env, ok := lookupEnv(field.Tag.Get("env"))
+		if !ok || env == "" {
+			continue
+		}
+
+		err := setField(value.Field(i), env)
+		if err != nil {
+			return fmt.Errorf("%s: %w", field.Tag.Get("env"), err)
+		}
+	}
+
+	return nil
+}
+
+func setField(field reflect.Value, env string) error {
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(env)
+	case time.Duration:
+		duration, err := time.ParseDuration(env)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(duration))
+	case int:
+		number, err := strconv.Atoi(env)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(number))
+	case bool:
+		boolean, err := strconv.ParseBool(env)
+		if err != nil {
+			return err
+		}
+		field.SetBool(boolean)
+	case []string:
+		field.Set(reflect.ValueOf(strings.Split(env, ",")))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+
+	return nil
+}
+
+const (
+	CryptoProviderEnv     = "env"
+	CryptoProviderFile    = "file"
+	CryptoProviderTransit = "transit"
+)
+
+// DefaultCryptoKeyID identifies crypto_key in the keyring
+const DefaultCryptoKeyID = "default"
+
+// Crypto builds the keyring of the crypto provider
+func (c Config) Crypto() (crypto.Crypto, error) {
+	switch c.CryptoProvider {
+	case CryptoProviderEnv:
+		env, err := c.envKeyProvider(c.CryptoActiveKey)
+		if err != nil {
+			return nil, err
+		}
+		return crypto.NewCrypto(env), nil
+	case CryptoProviderFile:
+		file, err := crypto.NewFileKeyProvider(c.CryptoKeyFile, c.CryptoActiveKey)
+		if err != nil {
+			return nil, err
+		}
+		return crypto.NewCrypto(file), nil
+	case CryptoProviderTransit:
+		transit, err := crypto.NewTransitKeyProvider(crypto.NewTransitClient(c.TransitAddress, c.TransitToken, c.TransitMount), c.TransitKey)
+		if err != nil {
+			return nil, err
+		}
+
+		// None of the local keys seals new values anymore, so which one is
+		// active doesn't matter
+		var previous []crypto.KeyProvider
+		if c.CryptoKey != "" || len(c.CryptoKeys) > 0 {
+			env, err := c.envKeyProvider("")
+			if err != nil {
+				return nil, err
+			}
+			previous = append(previous, env)
+		}
+		if c.CryptoKeyFile != "" {
+			file, err := crypto.NewFileKeyProvider(c.CryptoKeyFile, "")
+			if err != nil {
+				return nil, err
+			}
+			previous = append(previous, file)
+		}
+
+		return crypto.NewCrypto(transit, previous...), nil
+	default:
+		return nil, fmt.Errorf("crypto_provider must be %s, %s or %s, got %q", CryptoProviderEnv, CryptoProviderFile, CryptoProviderTransit, c.CryptoProvider)
+	}
+}
+
+// CryptoKeyring lists the keys of crypto_key and crypto_keys
+func (c Config) CryptoKeyring() ([]crypto.Key, error) {
+	var keys []crypto.Key
+	if c.CryptoKey != "" {
+		keys = append(keys, crypto.Key{ID: DefaultCryptoKeyID, Secret: []byte(c.CryptoKey)})
+	}
+
+	entries, err := crypto.ParseKeys(c.CryptoKeys)
+	if err != nil {
+		return nil, fmt.Errorf("crypto_keys: %w", err)
+	}
+
+	return append(keys, entries...), nil
+}
+
+func (c Config) envKeyProvider(active string) (crypto.KeyProvider, error) {
+	keys, err := c.CryptoKeyring()
+	if err != nil {
+		return nil, err
+	}
+
+	if active == "" && len(keys) > 0 {
+		active = keys[len(keys)-1].ID
+	}
+
+	return crypto.NewStaticKeyProvider(keys, active)
+}
+
+// Admin API tokens must be long enough not to be guessed
+const minAdminAPITokenLength = 32
+
+// AdminAPIEnabled tells whether operators have credentials for the admin API
+func (c Config) AdminAPIEnabled() bool {
+	return c.AdminAPIToken != "" || c.AdminAPIBasicAuth != ""
+}
+
+// AdminAPIBasicAuthCredentials splits admin_api_basic_auth
+func (c Config) AdminAPIBasicAuthCredentials() (username, password string) {
+	username, password, _ = strings.Cut(c.AdminAPIBasicAuth, ":")
+
+	return username, password
+}
+
+// TelemetryProvider resolves an empty Telemetry setting
+func (c Config) TelemetryProvider() string {
+	if c.Telemetry != "" {
+		return c.Telemetry
+	}
+	if c.NewRelicLicense != "" {
+		return telemetry.NewRelic
+	}
+	return telemetry.None
+}
+
+// Validate reports every missing or invalid setting at once
+func (c Config) Validate() error {
+	var errs []error
+
+	required := map[string]string{
+		"database_url":          c.DatabaseURL,
+		"slack_auth_url":        c.SlackAuthURL,
+		"slack_client_id":       c.SlackClientID,
+		"slack_client_secret":   c.SlackClientSecret,
+		"slack_signing_secret":  c.SlackSigningSecret,
+		"spotify_redirect_url":  c.SpotifyRedirectURL,
+		"spotify_client_id":     c.SpotifyClientID,
+		"spotify_client_secret": c.SpotifyClientSecret,
+	}
+	for _, key := range sortedKeys(required) {
+		if required[key] == "" {
+			errs = append(errs, fmt.Errorf("%s is required", key))
+		}
+	}
+
+	var cryptoErrs []error
+
+	// AES-128, AES-192 or AES-256
+	switch len(c.CryptoKey) {
+	case 0, 16, 24, 32:
+	default:
+		cryptoErrs = append(cryptoErrs, fmt.Errorf("crypto_key must be 16, 24 or 32 bytes long, got %d", len(c.CryptoKey)))
+	}
+
+	switch c.CryptoProvider {
+	case CryptoProviderEnv:
+		if c.CryptoKey == "" && len(c.CryptoKeys) == 0 {
+			cryptoErrs = append(cryptoErrs, errors.New("crypto_key or crypto_keys is required"))
+		}
+	case CryptoProviderFile:
+		if c.CryptoKeyFile == "" {
+			cryptoErrs = append(cryptoErrs, errors.New("crypto_key_file is required by the file crypto provider"))
+		}
+	case CryptoProviderTransit:
+		if c.TransitAddress == "" || c.TransitKey == "" {
+			cryptoErrs = append(cryptoErrs, errors.New("transit_address and transit_key are required by the transit crypto provider"))
+		}
+	}
+
+	// Building the keyring reads the key file but doesn't reach the transit
+	// engine
+	if len(cryptoErrs) == 0 {
+		if _, err := c.Crypto(); err != nil {
+			cryptoErrs = append(cryptoErrs, err)
+		}
+	}
+	errs = append(errs, cryptoErrs...)
+
+	switch strings.ToLower(c.LogLevel) {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Errorf("log_level must be debug, info, warn or error, got %q", c.LogLevel))
+	}
+
+	switch c.LogFormat {
+	case logging.FormatText, logging.FormatJSON:
+	default:
+		errs = append(errs, fmt.Errorf("log_format must be %s or %s, got %q", logging.FormatText, logging.FormatJSON, c.LogFormat))
+	}
+
+	switch c.Telemetry {
+	case "", telemetry.None, telemetry.OTLP:
+	case telemetry.NewRelic:
+		if c.NewRelicLicense == "" {
+			errs = append(errs, errors.New("new_relic_license is required by the newrelic telemetry"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("telemetry must be %s, %s or %s, got %q", telemetry.NewRelic, telemetry.OTLP, telemetry.None, c.Telemetry))
+	}
+
+	positive := map[string]time.Duration{
+		"graceful_timeout":            c.GracefulTimeout,
+		"poll_interval":               c.PollInterval,
+		"ready_poll_max_age":          c.ReadyPollMaxAge,
+		"leader_lease_ttl":            c.LeaderLeaseTTL,
+		"listening_history_retention": c.ListeningHistoryRetention,
+		"audit_retention":             c.AuditRetention,
+	}
+	for _, key := range sortedKeys(positive) {
+		if positive[key] <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", key))
+		}
+	}
+
+	// Zero disables the query timeout
+	if c.DatabaseQueryTimeout < 0 {
+		errs = append(errs, errors.New("database_query_timeout can't be negative"))
+	}
+
+	// One slow or failed tick shouldn't take a replica out of rotation
+	if c.ReadyPollMaxAge > 0 && c.ReadyPollMaxAge < 2*c.PollInterval {
+		errs = append(errs, errors.New("ready_poll_max_age must be at least twice poll_interval"))
+	}
+
+	if c.PollShards < 1 || c.PollShards > domain.PollBuckets {
+		errs = append(errs, fmt.Errorf("poll_shards must be between 1 and %d", domain.PollBuckets))
+	}
+
+	if c.ChartsMinListeners < 1 {
+		errs = append(errs, errors.New("charts_min_listeners must be at least 1"))
+	}
+
+	if username, password := c.AdminAPIBasicAuthCredentials(); c.AdminAPIBasicAuth != "" && (username == "" || password == "") {
+		errs = append(errs, errors.New("admin_api_basic_auth must be \"user:password\""))
+	}
+	if c.AdminAPIToken != "" && len(c.AdminAPIToken) < minAdminAPITokenLength {
+		errs = append(errs, fmt.Errorf("admin_api_token must be at least %d characters long", minAdminAPITokenLength))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Redacted lists the settings as "key: value" lines, with the secrets
+// masked
+func (c Config) Redacted() string {
+	var builder strings.Builder
+
+	value := reflect.ValueOf(c)
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+
+		text := fmt.Sprint(value.Field(i).Interface())
+		if field.Tag.Get("secret") == "true" && !value.Field(i).IsZero() {
+			text = "[redacted]"
+		}
+
+		fmt.Fprintf(&builder, "%s: %s\n", field.Tag.Get("yaml"), text)
+	}
+
+	return builder.String()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
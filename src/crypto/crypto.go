@@ -5,64 +5,214 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"strings"
+)
+
+// Ciphertexts are "v2:<key reference>:<hex of the nonce and the sealed
+// value>", bound to their associated data. "v1" values were sealed without
+// it, and values encrypted before key references existed are bare hex,
+// opened by trying every local key.
+const (
+	versionUnbound = "v1"
+	version        = "v2"
+)
+
+var (
+	ErrUnknownKey = errors.New("crypto: ciphertext sealed with an unknown key")
+	ErrMalformed  = errors.New("crypto: malformed ciphertext")
 )
 
 type crypto struct {
-	key []byte
+	// New values are sealed with the first provider's data keys, the others
+	// only open values sealed before
+	providers []KeyProvider
 }
 
+// Crypto seals values along with associated data, like their owner and
+// field, which must be given again to open them, so a value copied
+// elsewhere doesn't decrypt
 type Crypto interface {
-	Encrypt(value string) (string, error)
-	Decrypt(encValue string) ([]byte, error)
+	Encrypt(value string, associatedData []byte) (string, error)
+	Decrypt(encValue string, associatedData []byte) ([]byte, error)
+	NeedsRotation(encValue string) bool
+	Check() error
+}
+
+// AssociatedData binds a value to the record and field it's stored in
+func AssociatedData(recordID, field string) []byte {
+	return []byte(recordID + "\x00" + field)
+}
+
+// NewCrypto seals new values with keys of active, and opens values sealed
+// with keys of active or previous, which are kept until every value is
+// rotated
+func NewCrypto(active KeyProvider, previous ...KeyProvider) Crypto {
+	return crypto{append([]KeyProvider{active}, previous...)}
 }
 
-func NewCrypto(key []byte) Crypto {
-	return crypto{key}
+// NewKeyring is a Crypto over local keys only
+func NewKeyring(keys []Key, activeID string) (Crypto, error) {
+	provider, err := NewStaticKeyProvider(keys, activeID)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCrypto(provider), nil
 }
 
-func (c crypto) Encrypt(value string) (string, error) {
-	cip, err := aes.NewCipher(c.key)
+func (c crypto) Encrypt(value string, associatedData []byte) (string, error) {
+	key, ref, err := c.providers[0].DataKey()
 	if err != nil {
 		return "", err
 	}
 
-	gcm, err := cipher.NewGCM(cip)
+	gcm, err := newGCM(key)
 	if err != nil {
 		return "", err
 	}
 
 	nonce := make([]byte, gcm.NonceSize())
-	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return "", err
 	}
 
-	return hex.EncodeToString(gcm.Seal(nonce, nonce, []byte(value), nil)), nil
+	sealed := hex.EncodeToString(gcm.Seal(nonce, nonce, []byte(value), associatedData))
+	return version + ":" + ref + ":" + sealed, nil
 }
 
-func (c crypto) Decrypt(encValue string) ([]byte, error) {
-	encByte, err := hex.DecodeString(encValue)
+// Decrypt ignores associatedData for values sealed without it, until they
+// are rotated
+func (c crypto) Decrypt(encValue string, associatedData []byte) ([]byte, error) {
+	valueVersion, ref, sealed, err := parse(encValue)
 	if err != nil {
 		return []byte{}, err
 	}
 
-	cip, err := aes.NewCipher(c.key)
+	if valueVersion == "" {
+		return c.decryptLegacy(sealed)
+	}
+
+	key, err := c.key(ref)
 	if err != nil {
 		return []byte{}, err
 	}
 
-	gcm, err := cipher.NewGCM(cip)
+	gcm, err := newGCM(key)
 	if err != nil {
 		return []byte{}, err
 	}
 
+	if valueVersion == versionUnbound {
+		associatedData = nil
+	}
+	return open(gcm, sealed, associatedData)
+}
+
+func (c crypto) key(ref string) ([]byte, error) {
+	for _, provider := range c.providers {
+		key, err := provider.Key(ref)
+		if errors.Is(err, ErrUnknownKey) {
+			continue
+		}
+
+		return key, err
+	}
+
+	return nil, fmt.Errorf("%w %q", ErrUnknownKey, ref)
+}
+
+func (c crypto) decryptLegacy(sealed []byte) ([]byte, error) {
+	for _, provider := range c.providers {
+		local, ok := provider.(legacyKeyProvider)
+		if !ok {
+			continue
+		}
+
+		for _, key := range local.legacyKeys() {
+			gcm, err := newGCM(key)
+			if err != nil {
+				continue
+			}
+
+			decValue, err := open(gcm, sealed, nil)
+			if err == nil {
+				return decValue, nil
+			}
+		}
+	}
+
+	return []byte{}, ErrUnknownKey
+}
+
+// NeedsRotation tells whether encValue isn't sealed with a current key of
+// the active provider and with associated data, including values too
+// malformed to tell
+func (c crypto) NeedsRotation(encValue string) bool {
+	valueVersion, ref, _, err := parse(encValue)
+	return err != nil || valueVersion != version || !c.providers[0].Current(ref)
+}
+
+// Check round-trips a value, failing when the active provider can't be used
+func (c crypto) Check() error {
+	associatedData := AssociatedData("check", "check")
+
+	encValue, err := c.Encrypt("check", associatedData)
+	if err != nil {
+		return err
+	}
+
+	value, err := c.Decrypt(encValue, associatedData)
+	if err != nil {
+		return err
+	}
+	if string(value) != "check" {
+		return errors.New("crypto: round trip mismatch")
+	}
+
+	return nil
+}
+
+// parse returns an empty version for bare hex values
+func parse(encValue string) (valueVersion, ref string, sealed []byte, err error) {
+	hexValue := encValue
+	if strings.Contains(encValue, ":") {
+		parts := strings.SplitN(encValue, ":", 3)
+		if len(parts) != 3 || (parts[0] != version && parts[0] != versionUnbound) {
+			return "", "", nil, ErrMalformed
+		}
+
+		valueVersion, ref, hexValue = parts[0], parts[1], parts[2]
+	}
+
+	sealed, err = hex.DecodeString(hexValue)
+	if err != nil {
+		return "", "", nil, ErrMalformed
+	}
+
+	return valueVersion, ref, sealed, nil
+}
+
+// newGCM accepts AES-128, AES-192 or AES-256 keys
+func newGCM(key []byte) (cipher.AEAD, error) {
+	cip, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(cip)
+}
+
+func open(gcm cipher.AEAD, sealed, associatedData []byte) ([]byte, error) {
 	nonceSize := gcm.NonceSize()
-	if len(encValue) < nonceSize {
-		return []byte{}, err
+	if len(sealed) < nonceSize {
+		return []byte{}, ErrMalformed
 	}
 
-	nonce, encByte := encByte[:nonceSize], encByte[nonceSize:]
-	decValue, err := gcm.Open(nil, nonce, encByte, nil)
+	nonce, sealed := sealed[:nonceSize], sealed[nonceSize:]
+	decValue, err := gcm.Open(nil, nonce, sealed, associatedData)
 	if err != nil {
 		return []byte{}, err
 	}
@@ -0,0 +1,126 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var (
+	oldKey = Key{ID: "2023", Secret: []byte("0123456789abcdef")}
+	newKey = Key{ID: "2024", Secret: []byte("fedcba9876543210fedcba9876543210")}
+)
+
+func newKeyring(t *testing.T, activeID string, keys ...Key) Crypto {
+	t.Helper()
+
+	c, err := NewKeyring(keys, activeID)
+	if err != nil {
+		t.Fatalf("NewKeyring: %s", err)
+	}
+	return c
+}
+
+var associatedData = AssociatedData("user-1", "slack_access_token")
+
+func TestRotation(t *testing.T) {
+	before := newKeyring(t, oldKey.ID, oldKey)
+	encValue, err := before.Encrypt("xoxp-token", associatedData)
+	if err != nil {
+		t.Fatalf("Encrypt: %s", err)
+	}
+	if !strings.HasPrefix(encValue, "v2:2023:") {
+		t.Errorf("Encrypt = %q, want it prefixed with the version and key ID", encValue)
+	}
+
+	after := newKeyring(t, newKey.ID, oldKey, newKey)
+	if !after.NeedsRotation(encValue) {
+		t.Errorf("NeedsRotation of a value sealed with the old key = false")
+	}
+
+	value, err := after.Decrypt(encValue, associatedData)
+	if err != nil || string(value) != "xoxp-token" {
+		t.Fatalf("Decrypt with the old key still in the ring = %q, %v", value, err)
+	}
+
+	rotated, err := after.Encrypt(string(value), associatedData)
+	if err != nil {
+		t.Fatalf("Encrypt: %s", err)
+	}
+	if after.NeedsRotation(rotated) {
+		t.Errorf("NeedsRotation of a value sealed with the active key = true")
+	}
+
+	retired := newKeyring(t, newKey.ID, newKey)
+	if _, err := retired.Decrypt(encValue, associatedData); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Decrypt after the old key is retired = %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestAssociatedData(t *testing.T) {
+	c := newKeyring(t, oldKey.ID, oldKey)
+	encValue, err := c.Encrypt("xoxp-token", associatedData)
+	if err != nil {
+		t.Fatalf("Encrypt: %s", err)
+	}
+
+	// Copied to another user's row, or to another field
+	for _, other := range [][]byte{AssociatedData("user-2", "slack_access_token"), AssociatedData("user-1", "spotify_access_token"), nil} {
+		if _, err := c.Decrypt(encValue, other); err == nil {
+			t.Errorf("Decrypt with associated data %q succeeded", other)
+		}
+	}
+
+	// Nor can the value pass for one sealed without associated data
+	downgraded := "v1" + strings.TrimPrefix(encValue, "v2")
+	if _, err := c.Decrypt(downgraded, associatedData); err == nil {
+		t.Errorf("Decrypt of a value relabeled as unbound succeeded")
+	}
+}
+
+func TestLegacyCiphertexts(t *testing.T) {
+	// Sealed the way values were before key IDs, then before associated data
+	cip, _ := aes.NewCipher(oldKey.Secret)
+	gcm, _ := cipher.NewGCM(cip)
+	nonce := make([]byte, gcm.NonceSize())
+	legacy := hex.EncodeToString(gcm.Seal(nonce, nonce, []byte("xoxb-token"), nil))
+	unbound := "v1:" + oldKey.ID + ":" + legacy
+
+	c := newKeyring(t, newKey.ID, newKey, oldKey)
+	for _, encValue := range []string{legacy, unbound} {
+		value, err := c.Decrypt(encValue, associatedData)
+		if err != nil || string(value) != "xoxb-token" {
+			t.Fatalf("Decrypt(%q) = %q, %v", encValue, value, err)
+		}
+		if !c.NeedsRotation(encValue) {
+			t.Errorf("NeedsRotation(%q) = false", encValue)
+		}
+	}
+
+	for _, malformed := range []string{"v3:2023:00", "v2:2023:zz", "v2:2023:00"} {
+		if _, err := c.Decrypt(malformed, associatedData); !errors.Is(err, ErrMalformed) {
+			t.Errorf("Decrypt(%q) = %v, want %v", malformed, err, ErrMalformed)
+		}
+	}
+}
+
+func TestNewKeyringErrors(t *testing.T) {
+	tests := map[string]struct {
+		keys   []Key
+		active string
+	}{
+		"short key":      {[]Key{{ID: "a", Secret: []byte("short")}}, "a"},
+		"invalid ID":     {[]Key{{ID: "a:b", Secret: oldKey.Secret}}, "a:b"},
+		"duplicate ID":   {[]Key{oldKey, oldKey}, oldKey.ID},
+		"unknown active": {[]Key{oldKey}, newKey.ID},
+	}
+
+	for name, test := range tests {
+		if _, err := NewKeyring(test.keys, test.active); err == nil {
+			t.Errorf("NewKeyring with a %s succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Key IDs can't hold '.', which separates the parts of transit references
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// KeyProvider hands out the data keys values are sealed with. Each key has
+// a reference, stored in the ciphertexts it seals, to find it again.
+type KeyProvider interface {
+	// DataKey returns the key to seal a new value with, and its reference
+	DataKey() (key []byte, ref string, err error)
+	// Key returns the key ref points to, or ErrUnknownKey when ref isn't
+	// one of this provider's
+	Key(ref string) ([]byte, error)
+	// Current tells whether ref is still how DataKey would seal a value,
+	// or whether values sealed with it should be rotated
+	Current(ref string) bool
+}
+
+// legacyKeyProvider holds the local keys tried on values sealed before key
+// references existed
+type legacyKeyProvider interface {
+	legacyKeys() [][]byte
+}
+
+// Key is an AES-128, AES-192 or AES-256 key with the ID stored next to the
+// values it seals
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+type staticKeys struct {
+	active string
+	// In the order legacy values are tried, the active one first
+	ids  []string
+	keys map[string][]byte
+}
+
+// NewStaticKeyProvider seals every value with the key activeID, and opens
+// values sealed with any of keys. The keys typically come from the
+// environment.
+func NewStaticKeyProvider(keys []Key, activeID string) (KeyProvider, error) {
+	p := staticKeys{
+		active: activeID,
+		ids:    []string{activeID},
+		keys:   make(map[string][]byte, len(keys)),
+	}
+
+	for _, key := range keys {
+		if !keyIDPattern.MatchString(key.ID) {
+			return nil, fmt.Errorf("crypto: invalid key ID %q, use letters, digits, '_' or '-'", key.ID)
+		}
+		if _, ok := p.keys[key.ID]; ok {
+			return nil, fmt.Errorf("crypto: duplicate key ID %q", key.ID)
+		}
+		if _, err := newGCM(key.Secret); err != nil {
+			return nil, fmt.Errorf("crypto: key %q must be 16, 24 or 32 bytes long, got %d", key.ID, len(key.Secret))
+		}
+
+		p.keys[key.ID] = key.Secret
+		if key.ID != activeID {
+			p.ids = append(p.ids, key.ID)
+		}
+	}
+
+	if _, ok := p.keys[activeID]; !ok {
+		return nil, fmt.Errorf("crypto: active key %q is not in the keyring", activeID)
+	}
+
+	return p, nil
+}
+
+// NewFileKeyProvider reads the keys from a file of "id:key" lines, blank
+// lines and lines starting with '#' aside, so they can be mounted as a
+// secret instead of set in the environment. An empty activeID picks the
+// last key.
+func NewFileKeyProvider(path, activeID string) (KeyProvider, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []string
+	scanner := bufio.NewScanner(bytes.NewReader(file))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+
+	keys, err := ParseKeys(entries)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no keys", path)
+	}
+
+	if activeID == "" {
+		activeID = keys[len(keys)-1].ID
+	}
+
+	return NewStaticKeyProvider(keys, activeID)
+}
+
+// ParseKeys reads "id:key" entries
+func ParseKeys(entries []string) ([]Key, error) {
+	keys := make([]Key, 0, len(entries))
+	for _, entry := range entries {
+		id, secret, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("crypto: key entries must be \"id:key\"")
+		}
+		keys = append(keys, Key{ID: id, Secret: []byte(secret)})
+	}
+
+	return keys, nil
+}
+
+func (p staticKeys) DataKey() ([]byte, string, error) {
+	return p.keys[p.active], p.active, nil
+}
+
+func (p staticKeys) Key(ref string) ([]byte, error) {
+	key, ok := p.keys[ref]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	return key, nil
+}
+
+func (p staticKeys) Current(ref string) bool {
+	return ref == p.active
+}
+
+func (p staticKeys) legacyKeys() [][]byte {
+	keys := make([][]byte, len(p.ids))
+	for i, id := range p.ids {
+		keys[i] = p.keys[id]
+	}
+
+	return keys
+}
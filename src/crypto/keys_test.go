@@ -0,0 +1,93 @@
+package crypto
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileKeyProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	file := "# retired once rotated\n2023:0123456789abcdef\n\n2024:fedcba9876543210fedcba9876543210\n"
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	provider, err := NewFileKeyProvider(path, "")
+	if err != nil {
+		t.Fatalf("NewFileKeyProvider: %s", err)
+	}
+	if _, ref, _ := provider.DataKey(); ref != "2024" {
+		t.Errorf("DataKey reference = %q, want the last key", ref)
+	}
+	if key, err := provider.Key("2023"); err != nil || string(key) != "0123456789abcdef" {
+		t.Errorf("Key(2023) = %q, %v", key, err)
+	}
+
+	if err := os.WriteFile(path, []byte("0123456789abcdef\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileKeyProvider(path, ""); err == nil {
+		t.Error("NewFileKeyProvider of a line without ID succeeded")
+	}
+}
+
+func newTransitProvider(t *testing.T, address, token, keyName string) KeyProvider {
+	t.Helper()
+
+	provider, err := NewTransitKeyProvider(NewTransitClient(address, token, "transit"), keyName)
+	if err != nil {
+		t.Fatalf("NewTransitKeyProvider: %s", err)
+	}
+	return provider
+}
+
+func TestTransitKeyProvider(t *testing.T) {
+	transit, err := NewLocalTransit([]Key{{ID: "spotify-status", Secret: newKey.Secret}}, "vault-token")
+	if err != nil {
+		t.Fatalf("NewLocalTransit: %s", err)
+	}
+	server := httptest.NewServer(transit)
+	defer server.Close()
+
+	// Values sealed with a local key before moving to the transit engine
+	local, err := NewStaticKeyProvider([]Key{oldKey}, oldKey.ID)
+	if err != nil {
+		t.Fatalf("NewStaticKeyProvider: %s", err)
+	}
+	before, err := NewCrypto(local).Encrypt("xoxp-old", associatedData)
+	if err != nil {
+		t.Fatalf("Encrypt: %s", err)
+	}
+
+	c := NewCrypto(newTransitProvider(t, server.URL, "vault-token", "spotify-status"), local)
+	encValue, err := c.Encrypt("xoxp-token", associatedData)
+	if err != nil {
+		t.Fatalf("Encrypt: %s", err)
+	}
+	if !strings.HasPrefix(encValue, "v2:transit.spotify-status.") || c.NeedsRotation(encValue) {
+		t.Errorf("Encrypt = %q, want it sealed with a wrapped data key", encValue)
+	}
+	if !c.NeedsRotation(before) {
+		t.Errorf("NeedsRotation of a value sealed with a local key = false")
+	}
+	if value, err := c.Decrypt(before, associatedData); err != nil || string(value) != "xoxp-old" {
+		t.Errorf("Decrypt of a value sealed with a local key = %q, %v", value, err)
+	}
+
+	// Without the cached data key, it's unwrapped by the transit engine
+	fresh := NewCrypto(newTransitProvider(t, server.URL, "vault-token", "spotify-status"))
+	if value, err := fresh.Decrypt(encValue, associatedData); err != nil || string(value) != "xoxp-token" {
+		t.Errorf("Decrypt with a new provider = %q, %v", value, err)
+	}
+
+	denied := NewCrypto(newTransitProvider(t, server.URL, "wrong-token", "spotify-status"))
+	if _, err := denied.Decrypt(encValue, associatedData); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("Decrypt with a wrong token = %v, want permission denied", err)
+	}
+	if err := denied.Check(); err == nil {
+		t.Error("Check with a wrong token succeeded")
+	}
+}
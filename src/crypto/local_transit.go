@@ -0,0 +1,150 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Ciphertexts of LocalTransit, shaped like Vault's
+const localTransitPrefix = "vault:v1:"
+
+type localTransit struct {
+	keys  map[string][]byte
+	token string
+}
+
+// NewLocalTransit serves the part of the Vault transit API the app uses
+// (datakey/plaintext, encrypt and decrypt) with keys as named master keys,
+// for development and tests without a Vault. Requests must carry token in
+// X-Vault-Token unless it's empty.
+func NewLocalTransit(keys []Key, token string) (http.Handler, error) {
+	t := localTransit{keys: make(map[string][]byte, len(keys)), token: token}
+	for _, key := range keys {
+		if _, err := newGCM(key.Secret); err != nil {
+			return nil, err
+		}
+		t.keys[key.ID] = key.Secret
+	}
+
+	return t, nil
+}
+
+func (t localTransit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if t.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Vault-Token")), []byte(t.token)) != 1 {
+		writeTransit(w, http.StatusForbidden, transitResponse{Errors: []string{"permission denied"}})
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeTransit(w, http.StatusMethodNotAllowed, transitResponse{Errors: []string{"unsupported method"}})
+		return
+	}
+
+	// /v1/<mount>/<operation>/<key name>, whatever the mount
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/v1/"), "/", 2)
+	if len(parts) != 2 {
+		writeTransit(w, http.StatusNotFound, transitResponse{Errors: []string{"unsupported path"}})
+		return
+	}
+	operation, keyName, _ := strings.Cut(parts[1], "/")
+	if operation == "datakey" {
+		keyName = strings.TrimPrefix(keyName, "plaintext/")
+	}
+
+	key, ok := t.keys[keyName]
+	if !ok {
+		writeTransit(w, http.StatusBadRequest, transitResponse{Errors: []string{"encryption key not found"}})
+		return
+	}
+
+	var req transitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeTransit(w, http.StatusBadRequest, transitResponse{Errors: []string{err.Error()}})
+		return
+	}
+
+	var resp transitResponse
+	var err error
+	switch operation {
+	case "datakey":
+		resp, err = localDataKey(key, req.Bits)
+	case "encrypt":
+		var plaintext []byte
+		plaintext, err = base64.StdEncoding.DecodeString(req.Plaintext)
+		if err == nil {
+			resp.Data.Ciphertext, err = localWrap(key, plaintext)
+		}
+	case "decrypt":
+		var plaintext []byte
+		plaintext, err = localUnwrap(key, req.Ciphertext)
+		resp.Data.Plaintext = base64.StdEncoding.EncodeToString(plaintext)
+	default:
+		writeTransit(w, http.StatusNotFound, transitResponse{Errors: []string{"unsupported path"}})
+		return
+	}
+	if err != nil {
+		writeTransit(w, http.StatusBadRequest, transitResponse{Errors: []string{err.Error()}})
+		return
+	}
+
+	writeTransit(w, http.StatusOK, resp)
+}
+
+func localDataKey(key []byte, bits int) (transitResponse, error) {
+	if bits == 0 {
+		bits = 256
+	}
+
+	plaintext := make([]byte, bits/8)
+	if _, err := io.ReadFull(rand.Reader, plaintext); err != nil {
+		return transitResponse{}, err
+	}
+
+	var resp transitResponse
+	var err error
+	resp.Data.Plaintext = base64.StdEncoding.EncodeToString(plaintext)
+	resp.Data.Ciphertext, err = localWrap(key, plaintext)
+	return resp, err
+}
+
+func localWrap(key, plaintext []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	return localTransitPrefix + base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+func localUnwrap(key []byte, ciphertext string) ([]byte, error) {
+	if !strings.HasPrefix(ciphertext, localTransitPrefix) {
+		return nil, ErrMalformed
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(ciphertext, localTransitPrefix))
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(gcm, sealed, nil)
+}
+
+func writeTransit(w http.ResponseWriter, status int, resp transitResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,193 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TransitClient calls the transit secrets engine of HashiCorp Vault, or
+// any service with the same API, like LocalTransit
+type TransitClient struct {
+	address    string
+	token      string
+	mount      string
+	httpClient *http.Client
+}
+
+func NewTransitClient(address, token, mount string) *TransitClient {
+	return &TransitClient{
+		address:    strings.TrimSuffix(address, "/"),
+		token:      token,
+		mount:      mount,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// GenerateDataKey returns a new 256 bits data key, and the same key wrapped
+// by the master key keyName
+func (c *TransitClient) GenerateDataKey(keyName string) ([]byte, string, error) {
+	var resp transitResponse
+	err := c.post("datakey/plaintext/"+keyName, transitRequest{Bits: 256}, &resp)
+	if err != nil {
+		return nil, "", err
+	}
+
+	plaintext, err := base64.StdEncoding.DecodeString(resp.Data.Plaintext)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return plaintext, resp.Data.Ciphertext, nil
+}
+
+// Decrypt unwraps ciphertext with the master key keyName
+func (c *TransitClient) Decrypt(keyName, ciphertext string) ([]byte, error) {
+	var resp transitResponse
+	err := c.post("decrypt/"+keyName, transitRequest{Ciphertext: ciphertext}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.DecodeString(resp.Data.Plaintext)
+}
+
+type transitRequest struct {
+	Bits       int    `json:"bits,omitempty"`
+	Plaintext  string `json:"plaintext,omitempty"`
+	Ciphertext string `json:"ciphertext,omitempty"`
+}
+
+type transitResponse struct {
+	Data struct {
+		Plaintext  string `json:"plaintext,omitempty"`
+		Ciphertext string `json:"ciphertext,omitempty"`
+	} `json:"data"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+func (c *TransitClient) post(path string, body transitRequest, resp *transitResponse) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.address+"/v1/"+c.mount+"/"+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("X-Vault-Token", c.token)
+	}
+
+	httpResp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	err = json.NewDecoder(httpResp.Body).Decode(resp)
+	if httpResp.StatusCode != http.StatusOK {
+		if err == nil && len(resp.Errors) > 0 {
+			return fmt.Errorf("crypto: transit %s: %s", path, strings.Join(resp.Errors, ", "))
+		}
+		return fmt.Errorf("crypto: transit %s: status %d", path, httpResp.StatusCode)
+	}
+
+	return err
+}
+
+// References of transit data keys are "transit.<master key name>.<wrapped
+// data key in base64url>"
+const transitRefPrefix = "transit."
+
+// Unwrapped data keys are kept so polling doesn't call the transit engine
+// for each token. The cache is emptied once full.
+const maxCachedDataKeys = 10000
+
+type transitKeys struct {
+	client  *TransitClient
+	keyName string
+
+	mu    *sync.Mutex
+	cache map[string][]byte
+}
+
+// NewTransitKeyProvider does envelope encryption: every value is sealed
+// with its own data key, generated and wrapped by the master key keyName of
+// the transit engine, which never leaves it. The wrapped data key is the
+// value's key reference.
+func NewTransitKeyProvider(client *TransitClient, keyName string) (KeyProvider, error) {
+	if !keyIDPattern.MatchString(keyName) {
+		return nil, fmt.Errorf("crypto: invalid transit key name %q, use letters, digits, '_' or '-'", keyName)
+	}
+
+	return transitKeys{
+		client:  client,
+		keyName: keyName,
+		mu:      &sync.Mutex{},
+		cache:   map[string][]byte{},
+	}, nil
+}
+
+func (p transitKeys) DataKey() ([]byte, string, error) {
+	key, wrapped, err := p.client.GenerateDataKey(p.keyName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ref := transitRefPrefix + p.keyName + "." + base64.RawURLEncoding.EncodeToString([]byte(wrapped))
+	p.remember(ref, key)
+
+	return key, ref, nil
+}
+
+// Key unwraps data keys of any master key, so values stay readable after
+// the key name setting changes
+func (p transitKeys) Key(ref string) ([]byte, error) {
+	keyName, wrapped, ok := strings.Cut(strings.TrimPrefix(ref, transitRefPrefix), ".")
+	if !strings.HasPrefix(ref, transitRefPrefix) || !ok {
+		return nil, ErrUnknownKey
+	}
+
+	p.mu.Lock()
+	key, ok := p.cache[ref]
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	ciphertext, err := base64.RawURLEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, errors.Join(ErrMalformed, err)
+	}
+
+	key, err = p.client.Decrypt(keyName, string(ciphertext))
+	if err != nil {
+		return nil, err
+	}
+	p.remember(ref, key)
+
+	return key, nil
+}
+
+func (p transitKeys) Current(ref string) bool {
+	return strings.HasPrefix(ref, transitRefPrefix+p.keyName+".")
+}
+
+func (p transitKeys) remember(ref string, key []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.cache) >= maxCachedDataKeys {
+		clear(p.cache)
+	}
+	p.cache[ref] = key
+}
@@ -0,0 +1,44 @@
+package domain
+
+import "time"
+
+// What an audit event records
+const (
+	AuditUserAdded     = "user_added"
+	AuditUserEnabled   = "user_enabled"
+	AuditUserDisabled  = "user_disabled"
+	AuditUserRemoved   = "user_removed"
+	AuditStatusSet     = "status_set"
+	AuditStatusCleared = "status_cleared"
+)
+
+// Actors of what the app does on its own, like status writes, and of what
+// operators do through the admin API. Users acting on themselves are their
+// Slack user ID.
+const (
+	AuditActorApp   = "app"
+	AuditActorAdmin = "admin"
+)
+
+// AuditEvent records a consent change or what the app wrote to a profile.
+// Events are never updated, only removed once past the retention.
+type AuditEvent struct {
+	ID          string
+	SlackUserID string
+	SlackTeamID string
+	Action      string
+	Actor       string
+	Detail      string
+	CreatedAt   time.Time
+}
+
+// AuditEventFilter matches every event when empty. Events come newest first,
+// at most Limit of them when it's greater than zero.
+type AuditEventFilter struct {
+	SlackUserID string
+	SlackTeamID string
+	Action      string
+	From        time.Time
+	To          time.Time
+	Limit       int
+}
@@ -0,0 +1,11 @@
+package domain
+
+import "time"
+
+// Lease grants Holder exclusive use of Name until ExpiresAt, so a single
+// replica runs the scheduled jobs
+type Lease struct {
+	Name      string
+	Holder    string
+	ExpiresAt time.Time
+}
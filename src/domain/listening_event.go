@@ -0,0 +1,27 @@
+package domain
+
+import "time"
+
+type ListeningEvent struct {
+	ID          string
+	SlackUserID string
+	SlackTeamID string
+	TrackID     string
+	TrackName   string
+	Artists     []string
+	Album       string
+	Genres      []string
+	Duration    time.Duration
+	StartedAt   time.Time
+	EndedAt     time.Time
+}
+
+// ListeningEventFilter matches every event when empty. Events come in start
+// order, only the latest Limit of them when it's greater than zero.
+type ListeningEventFilter struct {
+	SlackUserID string
+	SlackTeamID string
+	From        time.Time
+	To          time.Time
+	Limit       int
+}
@@ -0,0 +1,17 @@
+package domain
+
+import "time"
+
+type ListeningSummary struct {
+	TopTracks  []ChartEntry
+	TopArtists []ChartEntry
+	TopGenres  []ChartEntry
+	TotalTime  time.Duration
+	Listeners  int
+}
+
+type ChartEntry struct {
+	Name      string
+	Plays     int
+	Listeners int
+}
@@ -0,0 +1,14 @@
+package domain
+
+import "time"
+
+type Track struct {
+	ID            string
+	Name          string
+	Artists       []string
+	Album         string
+	AlbumImageURL string
+	URL           string
+	Progress      time.Duration
+	Duration      time.Duration
+}
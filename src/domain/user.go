@@ -1,14 +1,101 @@
 package domain
 
-import "time"
+import (
+	"hash/fnv"
+	"strconv"
+	"time"
+)
 
 type User struct {
 	ID                  string
 	SlackUserID         string
+	SlackTeamID         string
 	SlackAccessToken    string
+	SlackBotAccessToken string
 	SpotifyAccessToken  string
 	SpotifyRefreshToken string
 	SpotifyExpiry       time.Time
 	SpotifyTokenType    string
 	Enabled             bool
+	ListeningHistory    bool
+	WeeklyDigest        bool
+	DigestWeekday       time.Weekday
+	DigestMinute        int
+	Timezone            string
+	DigestSentAt        time.Time
+	TeamVisible         bool
+	NowPlayingTrackID   string
+	NowPlayingTrack     string
+	NowPlayingArtists   string
+	NowPlayingUntil     time.Time
+	NextPollAt          time.Time
+	LastPlayedAt        time.Time
+	LastPollAt          time.Time
+	LastPollOutcome     string
+	LastPollError       string
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+}
+
+// PollBuckets is the number of hash buckets users are spread across, so
+// shard counts dividing it split them evenly
+const PollBuckets = 1024
+
+// UserShard selects the users whose poll bucket modulo Count is Index.
+// A Count below 2 selects everyone.
+type UserShard struct {
+	Index int
+	Count int
+}
+
+// UserPollFilter selects the enabled users of Shard due for a poll at DueAt.
+// A zero DueAt selects them regardless of their schedule. IncludeDisabled
+// selects disabled users too, for maintenance like key rotation.
+type UserPollFilter struct {
+	Shard           UserShard
+	DueAt           time.Time
+	IncludeDisabled bool
+}
+
+// PollOutcomeError is the LastPollOutcome of users whose last poll failed,
+// LastPollError telling why. The other outcomes are updated, cleared and
+// skipped, as counted by the poll metrics.
+const PollOutcomeError = "error"
+
+// UserListFilter pages through the users by Slack user ID, Limit at a time
+// from the one after AfterSlackUserID. Nil Enabled and Failing match every
+// user, Failing ones being those whose last poll was an error.
+type UserListFilter struct {
+	SlackTeamID      string
+	Enabled          *bool
+	Failing          *bool
+	AfterSlackUserID string
+	Limit            int
+}
+
+// ParseUserListFlag reads the Enabled and Failing filters, given as "true"
+// or "false", an empty value matching every user
+func ParseUserListFlag(value string) (*bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+func (shard UserShard) Includes(pollBucket int) bool {
+	return shard.Count < 2 || pollBucket%shard.Count == shard.Index
+}
+
+// UserPollBucket hashes the user ID into one of PollBuckets buckets
+func UserPollBucket(id string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(id))
+
+	return int(hash.Sum32() % PollBuckets)
 }
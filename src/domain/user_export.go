@@ -0,0 +1,12 @@
+package domain
+
+import "time"
+
+// UserExport gathers everything stored about a user
+type UserExport struct {
+	User            User
+	Workspace       Workspace
+	ListeningEvents []ListeningEvent
+	AuditEvents     []AuditEvent
+	ExportedAt      time.Time
+}
@@ -0,0 +1,16 @@
+package domain
+
+import "time"
+
+const (
+	ChartsPeriodWeek  = "week"
+	ChartsPeriodMonth = "month"
+)
+
+type Workspace struct {
+	SlackTeamID   string
+	AllowTeamView bool
+	ChartsChannel string
+	ChartsPeriod  string
+	ChartsSentAt  time.Time
+}
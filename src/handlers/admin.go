@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/o-mago/spotify-status/src/app_error"
+	"github.com/o-mago/spotify-status/src/domain"
+	"github.com/o-mago/spotify-status/src/logging"
+	"github.com/o-mago/spotify-status/src/services"
+)
+
+const defaultAdminUsersPage = 50
+
+// AdminCredentials are what operators authenticate with, a bearer token,
+// HTTP basic auth, or both. Empty credentials are never accepted.
+type AdminCredentials struct {
+	Token    string
+	Username string
+	Password string
+}
+
+type adminAPI struct {
+	services    services.Services
+	credentials AdminCredentials
+	logger      *slog.Logger
+}
+
+// NewAdminAPI serves the operators' JSON API, at paths relative to where it's
+// mounted:
+//
+//	GET    users?workspace=&enabled=&failing=&after=&limit=50
+//	GET    users/<slack user ID>/poll
+//	POST   users/<slack user ID>/disable
+//	POST   users/<slack user ID>/resync
+//	DELETE workspaces/<slack team ID>
+func NewAdminAPI(services services.Services, credentials AdminCredentials, logger *slog.Logger) http.Handler {
+	return adminAPI{services, credentials, logger}
+}
+
+func (a adminAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !a.authenticated(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="spotify-status admin", charset="UTF-8"`)
+		a.writeError(w, r, nil, app_error.AdminUnauthorized)
+
+		return
+	}
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case len(path) == 1 && path[0] == "users":
+		a.route(w, r, http.MethodGet, a.listUsers)
+	case len(path) == 3 && path[0] == "users" && path[2] == "poll":
+		a.route(w, r, http.MethodGet, func(w http.ResponseWriter, r *http.Request) { a.lastPoll(w, r, path[1]) })
+	case len(path) == 3 && path[0] == "users" && path[2] == "disable":
+		a.route(w, r, http.MethodPost, func(w http.ResponseWriter, r *http.Request) { a.disableUser(w, r, path[1]) })
+	case len(path) == 3 && path[0] == "users" && path[2] == "resync":
+		a.route(w, r, http.MethodPost, func(w http.ResponseWriter, r *http.Request) { a.resyncUser(w, r, path[1]) })
+	case len(path) == 2 && path[0] == "workspaces":
+		a.route(w, r, http.MethodDelete, func(w http.ResponseWriter, r *http.Request) { a.purgeWorkspace(w, r, path[1]) })
+	default:
+		a.writeError(w, r, nil, app_error.AdminRouteNotFound)
+	}
+}
+
+func (a adminAPI) route(w http.ResponseWriter, r *http.Request, method string, handler http.HandlerFunc) {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		a.writeError(w, r, nil, app_error.AdminMethodNotAllowed)
+
+		return
+	}
+
+	handler(w, r)
+}
+
+// authenticated compares hashes so the time taken doesn't tell the length
+// of the credentials
+func (a adminAPI) authenticated(r *http.Request) bool {
+	equal := func(given, want string) bool {
+		givenHash := sha256.Sum256([]byte(given))
+		wantHash := sha256.Sum256([]byte(want))
+
+		return want != "" && subtle.ConstantTimeCompare(givenHash[:], wantHash[:]) == 1
+	}
+
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return equal(token, a.credentials.Token)
+	}
+
+	if username, password, ok := r.BasicAuth(); ok {
+		return equal(username, a.credentials.Username) && equal(password, a.credentials.Password)
+	}
+
+	return false
+}
+
+type adminUser struct {
+	SlackUserID  string        `json:"slack_user_id"`
+	SlackTeamID  string        `json:"slack_team_id"`
+	Enabled      bool          `json:"enabled"`
+	NextPollAt   time.Time     `json:"next_poll_at"`
+	LastPlayedAt time.Time     `json:"last_played_at"`
+	LastPoll     adminLastPoll `json:"last_poll"`
+	CreatedAt    time.Time     `json:"created_at"`
+	UpdatedAt    time.Time     `json:"updated_at"`
+}
+
+type adminLastPoll struct {
+	At      time.Time `json:"at"`
+	Outcome string    `json:"outcome"`
+	Error   string    `json:"error,omitempty"`
+}
+
+func newAdminUser(user domain.User) adminUser {
+	return adminUser{
+		SlackUserID:  user.SlackUserID,
+		SlackTeamID:  user.SlackTeamID,
+		Enabled:      user.Enabled,
+		NextPollAt:   user.NextPollAt,
+		LastPlayedAt: user.LastPlayedAt,
+		LastPoll:     newAdminLastPoll(user),
+		CreatedAt:    user.CreatedAt,
+		UpdatedAt:    user.UpdatedAt,
+	}
+}
+
+func newAdminLastPoll(user domain.User) adminLastPoll {
+	return adminLastPoll{
+		At:      user.LastPollAt,
+		Outcome: user.LastPollOutcome,
+		Error:   user.LastPollError,
+	}
+}
+
+// listUsers returns a page of users, and the "next" cursor to pass as
+// "after" for the following page, empty on the last one
+func (a adminAPI) listUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := domain.UserListFilter{
+		SlackTeamID:      query.Get("workspace"),
+		AfterSlackUserID: query.Get("after"),
+		Limit:            defaultAdminUsersPage,
+	}
+
+	var err error
+	filter.Enabled, err = domain.ParseUserListFlag(query.Get("enabled"))
+	if err != nil {
+		a.writeError(w, r, err, app_error.InvalidAdminRequest)
+
+		return
+	}
+
+	filter.Failing, err = domain.ParseUserListFlag(query.Get("failing"))
+	if err != nil {
+		a.writeError(w, r, err, app_error.InvalidAdminRequest)
+
+		return
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit < 1 || filter.Limit > services.MaxUsersPage {
+			a.writeError(w, r, err, app_error.InvalidAdminRequest)
+
+			return
+		}
+	}
+
+	users, err := a.services.SearchUsersPage(r.Context(), filter)
+	if err != nil {
+		a.writeError(w, r, err, app_error.AdminError)
+
+		return
+	}
+
+	resp := struct {
+		Users []adminUser `json:"users"`
+		Next  string      `json:"next,omitempty"`
+	}{
+		Users: make([]adminUser, len(users)),
+	}
+	for i, user := range users {
+		resp.Users[i] = newAdminUser(user)
+	}
+	if len(users) == filter.Limit {
+		resp.Next = users[len(users)-1].SlackUserID
+	}
+
+	a.writeJSON(w, resp, http.StatusOK)
+}
+
+func (a adminAPI) lastPoll(w http.ResponseWriter, r *http.Request, slackUserID string) {
+	user, err := a.services.SearchUserBySlackID(r.Context(), slackUserID)
+	if err != nil {
+		a.writeError(w, r, err, app_error.AdminError)
+
+		return
+	}
+
+	a.writeJSON(w, newAdminLastPoll(user), http.StatusOK)
+}
+
+func (a adminAPI) disableUser(w http.ResponseWriter, r *http.Request, slackUserID string) {
+	err := a.services.DisableUser(r.Context(), slackUserID)
+	if err != nil {
+		a.writeError(w, r, err, app_error.AdminError)
+
+		return
+	}
+
+	user, err := a.services.SearchUserBySlackID(r.Context(), slackUserID)
+	if err != nil {
+		a.writeError(w, r, err, app_error.AdminError)
+
+		return
+	}
+
+	a.writeJSON(w, newAdminUser(user), http.StatusOK)
+}
+
+func (a adminAPI) resyncUser(w http.ResponseWriter, r *http.Request, slackUserID string) {
+	user, err := a.services.ResyncUser(r.Context(), slackUserID)
+	if err != nil {
+		a.writeError(w, r, err, app_error.AdminError)
+
+		return
+	}
+
+	a.writeJSON(w, newAdminUser(user), http.StatusOK)
+}
+
+func (a adminAPI) purgeWorkspace(w http.ResponseWriter, r *http.Request, slackTeamID string) {
+	removed, err := a.services.PurgeWorkspace(r.Context(), slackTeamID)
+	if err != nil {
+		a.writeError(w, r, err, app_error.AdminError)
+
+		return
+	}
+
+	a.writeJSON(w, map[string]int{"removed_users": removed}, http.StatusOK)
+}
+
+// writeError answers with the app error err wraps, such as UserNotFound or
+// UserDisabled, else with fallback, logging unexpected failures
+func (a adminAPI) writeError(w http.ResponseWriter, r *http.Request, err error, fallback error) {
+	appError := fallback
+	if errors.Is(err, app_error.UserNotFound) {
+		appError = app_error.UserNotFound
+	}
+	if errors.Is(err, app_error.UserDisabled) {
+		appError = app_error.UserDisabled
+	}
+
+	status := http.StatusInternalServerError
+	var withStatus interface{ Status() int }
+	if errors.As(appError, &withStatus) {
+		status = withStatus.Status()
+	}
+
+	if status >= http.StatusInternalServerError {
+		logging.FromContext(r.Context(), a.logger).ErrorContext(r.Context(), "admin request failed", "error", err, "class", appError.Error())
+	}
+
+	a.writeJSON(w, map[string]string{"error": appError.Error()}, status)
+}
+
+func (a adminAPI) writeJSON(w http.ResponseWriter, resp interface{}, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		a.logger.Error("encoding the response failed", "error", err)
+	}
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/o-mago/spotify-status/src/crypto"
+	"github.com/o-mago/spotify-status/src/domain"
+	"github.com/o-mago/spotify-status/src/repositories"
+	"github.com/o-mago/spotify-status/src/services"
+)
+
+const adminToken = "0123456789abcdef0123456789abcdef"
+
+func newTestAdminAPI(t *testing.T, users ...domain.User) (http.Handler, repositories.Repositories) {
+	t.Helper()
+
+	keyring, err := crypto.NewKeyring([]crypto.Key{{ID: "test", Secret: []byte("0123456789abcdef")}}, "test")
+	if err != nil {
+		t.Fatalf("NewKeyring: %s", err)
+	}
+
+	repo := repositories.NewMemoryRepository()
+	for _, user := range users {
+		if err := repo.CreateUser(context.Background(), user); err != nil {
+			t.Fatalf("CreateUser(%s): %s", user.SlackUserID, err)
+		}
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := services.NewServices(repo, nil, keyring, 0, 0, 1, logger)
+
+	return NewAdminAPI(s, AdminCredentials{Token: adminToken, Username: "operator", Password: "secret"}, logger), repo
+}
+
+func serveAdmin(t *testing.T, api http.Handler, method, path string, authenticate func(r *http.Request), resp interface{}) int {
+	t.Helper()
+
+	r := httptest.NewRequest(method, "/admin/api/"+path, nil)
+	if authenticate != nil {
+		authenticate(r)
+	}
+
+	recorder := httptest.NewRecorder()
+	http.StripPrefix("/admin/api/", api).ServeHTTP(recorder, r)
+
+	if resp != nil {
+		if err := json.NewDecoder(recorder.Body).Decode(resp); err != nil {
+			t.Fatalf("decoding the response of %s %s: %s", method, path, err)
+		}
+	}
+
+	return recorder.Code
+}
+
+func bearer(r *http.Request) {
+	r.Header.Set("Authorization", "Bearer "+adminToken)
+}
+
+func TestAdminAPIAuthentication(t *testing.T) {
+	api, _ := newTestAdminAPI(t)
+
+	authentications := map[string]struct {
+		authenticate func(r *http.Request)
+		want         int
+	}{
+		"none":         {nil, http.StatusUnauthorized},
+		"bearer":       {bearer, http.StatusOK},
+		"wrong bearer": {func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+strings.ToUpper(adminToken)) }, http.StatusUnauthorized},
+		"basic":        {func(r *http.Request) { r.SetBasicAuth("operator", "secret") }, http.StatusOK},
+		"wrong basic":  {func(r *http.Request) { r.SetBasicAuth("operator", "") }, http.StatusUnauthorized},
+	}
+
+	for name, test := range authentications {
+		if status := serveAdmin(t, api, http.MethodGet, "users", test.authenticate, nil); status != test.want {
+			t.Errorf("GET users with %s auth = %d, want %d", name, status, test.want)
+		}
+	}
+
+	// Without configured basic auth credentials, empty ones don't match
+	api = NewAdminAPI(nil, AdminCredentials{Token: adminToken}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if status := serveAdmin(t, api, http.MethodGet, "users", func(r *http.Request) { r.SetBasicAuth("", "") }, nil); status != http.StatusUnauthorized {
+		t.Errorf("GET users with empty basic auth = %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestAdminAPIUsers(t *testing.T) {
+	api, repo := newTestAdminAPI(t,
+		domain.User{ID: "user-1", SlackUserID: "U1", SlackTeamID: "T1", Enabled: true},
+		domain.User{ID: "user-2", SlackUserID: "U2", SlackTeamID: "T1", Enabled: true},
+		domain.User{ID: "user-3", SlackUserID: "U3", SlackTeamID: "T2", Enabled: true},
+	)
+
+	var page struct {
+		Users []adminUser `json:"users"`
+		Next  string      `json:"next"`
+	}
+	if status := serveAdmin(t, api, http.MethodGet, "users?limit=2", bearer, &page); status != http.StatusOK {
+		t.Fatalf("GET users = %d", status)
+	}
+	if len(page.Users) != 2 || page.Users[0].SlackUserID != "U1" || page.Next != "U2" {
+		t.Errorf("GET users?limit=2 = %+v, want U1 and U2 with a next page", page)
+	}
+
+	if status := serveAdmin(t, api, http.MethodGet, "users?enabled=maybe", bearer, nil); status != http.StatusBadRequest {
+		t.Errorf("GET users with an invalid filter = %d, want %d", status, http.StatusBadRequest)
+	}
+
+	var user adminUser
+	if status := serveAdmin(t, api, http.MethodPost, "users/U1/disable", bearer, &user); status != http.StatusOK || user.Enabled {
+		t.Errorf("POST users/U1/disable = %d %+v, want the disabled user", status, user)
+	}
+	if status := serveAdmin(t, api, http.MethodGet, "users/U1/disable", bearer, nil); status != http.StatusMethodNotAllowed {
+		t.Errorf("GET users/U1/disable = %d, want %d", status, http.StatusMethodNotAllowed)
+	}
+	if status := serveAdmin(t, api, http.MethodPost, "users/U404/disable", bearer, nil); status != http.StatusNotFound {
+		t.Errorf("POST users/U404/disable = %d, want %d", status, http.StatusNotFound)
+	}
+
+	// Disabled users aren't polled on an admin's behalf
+	if status := serveAdmin(t, api, http.MethodPost, "users/U1/resync", bearer, nil); status != http.StatusConflict {
+		t.Errorf("POST users/U1/resync of a disabled user = %d, want %d", status, http.StatusConflict)
+	}
+	resynced, err := repo.SearchUserBySlackID(context.Background(), "U1")
+	if err != nil {
+		t.Fatalf("SearchUserBySlackID: %s", err)
+	}
+	if !resynced.LastPollAt.IsZero() {
+		t.Errorf("LastPollAt = %s after resyncing a disabled user, want no poll", resynced.LastPollAt)
+	}
+
+	var purged map[string]int
+	if status := serveAdmin(t, api, http.MethodDelete, "workspaces/T1", bearer, &purged); status != http.StatusOK || purged["removed_users"] != 2 {
+		t.Errorf("DELETE workspaces/T1 = %d %v, want 2 removed users", status, purged)
+	}
+
+	users, err := repo.SearchUsersPage(context.Background(), domain.UserListFilter{})
+	if err != nil {
+		t.Fatalf("SearchUsersPage: %s", err)
+	}
+	if len(users) != 1 || users[0].SlackUserID != "U3" {
+		t.Errorf("users after purging T1 = %+v, want only U3", users)
+	}
+
+	events, err := repo.SearchAuditEvents(context.Background(), domain.AuditEventFilter{SlackTeamID: "T1"})
+	if err != nil {
+		t.Fatalf("SearchAuditEvents: %s", err)
+	}
+	actions := []string{}
+	for _, event := range events {
+		if event.Actor == domain.AuditActorAdmin {
+			actions = append(actions, event.Action)
+		}
+	}
+	if len(actions) != 3 {
+		t.Errorf("audit events by admins = %v, want a disable and 2 removals", actions)
+	}
+}
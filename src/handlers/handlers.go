@@ -5,9 +5,10 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -16,10 +17,16 @@ import (
 
 	"github.com/o-mago/spotify-status/src/app_error"
 	"github.com/o-mago/spotify-status/src/domain"
+	"github.com/o-mago/spotify-status/src/logging"
 	"github.com/o-mago/spotify-status/src/services"
+	"github.com/slack-go/slack"
 	"github.com/zmb3/spotify"
 )
 
+const shareShortcutCallbackID = "share_track"
+
+const commandUsage = "Usage: /spotify-status [share | team | privacy public | privacy private | history on | history off | digest on | digest off | digest <weekday> <HH:MM> [timezone] | charts [week | month] | export | workspace team-view on | workspace team-view off | workspace charts <#channel> week | workspace charts <#channel> month | workspace charts off | workspace audit [@user]]"
+
 type handlers struct {
 	services             services.Services
 	spotifyAuthenticator spotify.Authenticator
@@ -28,22 +35,24 @@ type handlers struct {
 	slackClientSecret    string
 	slackAuthURL         string
 	slackSigningSecret   string
+	logger               *slog.Logger
 }
 
 type Handlers interface {
-	HealthHandler(w http.ResponseWriter, r *http.Request)
 	SpotifyCallbackHandler(w http.ResponseWriter, r *http.Request)
 	SlackCallbackHandler(w http.ResponseWriter, r *http.Request)
 	OptInHandler(w http.ResponseWriter, r *http.Request)
 	OptOutHandler(w http.ResponseWriter, r *http.Request)
 	EnableHandler(w http.ResponseWriter, r *http.Request)
 	DisableHandler(w http.ResponseWriter, r *http.Request)
+	CommandHandler(w http.ResponseWriter, r *http.Request)
+	InteractivityHandler(w http.ResponseWriter, r *http.Request)
 
 	writeResponse(w http.ResponseWriter, resp interface{}, status int)
 }
 
 func NewHandlers(services services.Services, spotifyAuthenticator spotify.Authenticator,
-	spotifyState, slackClientID, slackClientSecret, slackAuthURL, slackSigningSecret string) Handlers {
+	spotifyState, slackClientID, slackClientSecret, slackAuthURL, slackSigningSecret string, logger *slog.Logger) Handlers {
 	return handlers{
 		services,
 		spotifyAuthenticator,
@@ -52,16 +61,37 @@ func NewHandlers(services services.Services, spotifyAuthenticator spotify.Authen
 		slackClientSecret,
 		slackAuthURL,
 		slackSigningSecret,
+		logger,
 	}
 }
 
+// logError logs a failed request with the class it's reported as, and the
+// hashed Slack user ID when the form or the cookies carry one
+func (h handlers) logError(r *http.Request, err error, appError error) {
+	logger := logging.FromContext(r.Context(), h.logger)
+
+	slackUserID := r.PostForm.Get("user_id")
+	if cookie, cookieErr := r.Cookie("user_id"); slackUserID == "" && cookieErr == nil {
+		slackUserID = cookie.Value
+	}
+	if slackUserID != "" {
+		logger = logger.With("slack_user", logging.HashID(slackUserID))
+	}
+
+	if appError != nil {
+		logger = logger.With("class", appError.Error())
+	}
+
+	logger.ErrorContext(r.Context(), "request failed", "error", err)
+}
+
 func (h handlers) SpotifyCallbackHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	userID, err := r.Cookie("user_id")
 	if err != nil {
 		appError := app_error.InvalidCookie
-		fmt.Println(err, appError)
+		h.logError(r, err, appError)
 		h.writeResponse(w, appError.Error(), appError.Status())
 
 		return
@@ -69,7 +99,23 @@ func (h handlers) SpotifyCallbackHandler(w http.ResponseWriter, r *http.Request)
 	slackAccessToken, err := r.Cookie("slack_access_token")
 	if err != nil {
 		appError := app_error.InvalidCookie
-		fmt.Println(err, appError)
+		h.logError(r, err, appError)
+		h.writeResponse(w, appError.Error(), appError.Status())
+
+		return
+	}
+	slackBotAccessToken, err := r.Cookie("slack_bot_access_token")
+	if err != nil {
+		appError := app_error.InvalidCookie
+		h.logError(r, err, appError)
+		h.writeResponse(w, appError.Error(), appError.Status())
+
+		return
+	}
+	slackTeamID, err := r.Cookie("slack_team_id")
+	if err != nil {
+		appError := app_error.InvalidCookie
+		h.logError(r, err, appError)
 		h.writeResponse(w, appError.Error(), appError.Status())
 
 		return
@@ -78,7 +124,7 @@ func (h handlers) SpotifyCallbackHandler(w http.ResponseWriter, r *http.Request)
 	spotifyToken, err := h.spotifyAuthenticator.Token(h.spotifyState, r)
 	if err != nil {
 		appError := app_error.InvalidSpotifyAuthCode
-		fmt.Println(err, appError)
+		h.logError(r, err, appError)
 		h.writeResponse(w, appError.Error(), appError.Status())
 
 		return
@@ -86,7 +132,9 @@ func (h handlers) SpotifyCallbackHandler(w http.ResponseWriter, r *http.Request)
 
 	user := domain.User{
 		SlackUserID:         userID.Value,
+		SlackTeamID:         slackTeamID.Value,
 		SlackAccessToken:    slackAccessToken.Value,
+		SlackBotAccessToken: slackBotAccessToken.Value,
 		SpotifyAccessToken:  spotifyToken.AccessToken,
 		SpotifyRefreshToken: spotifyToken.RefreshToken,
 		SpotifyExpiry:       spotifyToken.Expiry,
@@ -96,7 +144,7 @@ func (h handlers) SpotifyCallbackHandler(w http.ResponseWriter, r *http.Request)
 	err = h.services.AddUser(ctx, user)
 	if err != nil {
 		appError := app_error.AddUserError
-		fmt.Println(err, appError)
+		h.logError(r, err, appError)
 		h.writeResponse(w, appError.Error(), appError.Status())
 
 		return
@@ -113,10 +161,20 @@ func (h handlers) SlackCallbackHandler(w http.ResponseWriter, r *http.Request) {
 	requestBody.Set("client_id", h.slackClientID)
 	requestBody.Set("client_secret", h.slackClientSecret)
 
-	resp, err := http.Post(h.slackAuthURL, "application/x-www-form-urlencoded", strings.NewReader(requestBody.Encode()))
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, h.slackAuthURL, strings.NewReader(requestBody.Encode()))
+	if err != nil {
+		appError := app_error.SlackAuthBadRequest
+		h.logError(r, err, appError)
+		h.writeResponse(w, appError.Error(), appError.Status())
+
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		appError := app_error.SlackAuthBadRequest
-		fmt.Println(err, appError)
+		h.logError(r, err, appError)
 		h.writeResponse(w, appError.Error(), appError.Status())
 
 		return
@@ -127,16 +185,17 @@ func (h handlers) SlackCallbackHandler(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		appError := app_error.SlackAuthBadRequest
-		fmt.Println(err, appError)
+		h.logError(r, err, appError)
 		h.writeResponse(w, appError.Error(), appError.Status())
 
 		return
 	}
 
 	var slackAuthResponse struct {
-		Ok         bool   `json:"ok"`
-		AppId      string `json:"app_id"`
-		AuthedUser struct {
+		Ok          bool   `json:"ok"`
+		AppId       string `json:"app_id"`
+		AccessToken string `json:"access_token"`
+		AuthedUser  struct {
 			Id          string `json:"id"`
 			Scope       string `json:"scope"`
 			AccessToken string `json:"access_token"`
@@ -151,7 +210,7 @@ func (h handlers) SlackCallbackHandler(w http.ResponseWriter, r *http.Request) {
 	err = json.Unmarshal(body, &slackAuthResponse)
 	if err != nil {
 		appError := app_error.SlackAuthBadRequest
-		fmt.Println(err, appError)
+		h.logError(r, err, appError)
 		h.writeResponse(w, appError.Error(), appError.Status())
 
 		return
@@ -160,8 +219,12 @@ func (h handlers) SlackCallbackHandler(w http.ResponseWriter, r *http.Request) {
 	expiration := time.Now().Add(1 * time.Hour)
 	cookieUser := http.Cookie{Name: "user_id", Value: slackAuthResponse.AuthedUser.Id, Expires: expiration}
 	cookieSlack := http.Cookie{Name: "slack_access_token", Value: slackAuthResponse.AuthedUser.AccessToken, Expires: expiration}
+	cookieSlackBot := http.Cookie{Name: "slack_bot_access_token", Value: slackAuthResponse.AccessToken, Expires: expiration}
 	http.SetCookie(w, &cookieUser)
 	http.SetCookie(w, &cookieSlack)
+	cookieTeam := http.Cookie{Name: "slack_team_id", Value: slackAuthResponse.Team.Id, Expires: expiration}
+	http.SetCookie(w, &cookieSlackBot)
+	http.SetCookie(w, &cookieTeam)
 
 	spotifyAuthURL := h.spotifyAuthenticator.AuthURL(h.spotifyState)
 
@@ -171,13 +234,13 @@ func (h handlers) SlackCallbackHandler(w http.ResponseWriter, r *http.Request) {
 func (h handlers) OptInHandler(w http.ResponseWriter, r *http.Request) {
 	err := h.verifySlackSignature(w, r)
 	if err != nil {
-		fmt.Println(err)
+		h.logError(r, err, nil)
 		h.writeResponse(w, "error", http.StatusBadRequest)
 
 		return
 	}
 
-	h.writeResponse(w, "Please visit: https://slack.com/oauth/v2/authorize?client_id=1514600029252.1508440748514&scope=commands,chat:write&user_scope=users.profile:read,users.profile:write", http.StatusOK)
+	h.writeResponse(w, "Please visit: https://slack.com/oauth/v2/authorize?client_id=1514600029252.1508440748514&scope=commands,chat:write,im:write,files:write,users:read&user_scope=users.profile:read,users.profile:write", http.StatusOK)
 }
 
 func (h handlers) OptOutHandler(w http.ResponseWriter, r *http.Request) {
@@ -185,7 +248,7 @@ func (h handlers) OptOutHandler(w http.ResponseWriter, r *http.Request) {
 
 	err := h.verifySlackSignature(w, r)
 	if err != nil {
-		fmt.Println(err)
+		h.logError(r, err, nil)
 		h.writeResponse(w, "error", http.StatusBadRequest)
 
 		return
@@ -193,7 +256,7 @@ func (h handlers) OptOutHandler(w http.ResponseWriter, r *http.Request) {
 
 	err = r.ParseForm()
 	if err != nil {
-		fmt.Println(err)
+		h.logError(r, err, nil)
 
 		return
 	}
@@ -203,7 +266,7 @@ func (h handlers) OptOutHandler(w http.ResponseWriter, r *http.Request) {
 	err = h.services.RemoveUserBySlackID(ctx, slackUserID)
 	if err != nil {
 		appError := app_error.RemoveUserError
-		fmt.Println(err, appError)
+		h.logError(r, err, appError)
 		h.writeResponse(w, appError.Error(), appError.Status())
 
 		return
@@ -217,7 +280,7 @@ func (h handlers) EnableHandler(w http.ResponseWriter, r *http.Request) {
 
 	err := h.verifySlackSignature(w, r)
 	if err != nil {
-		fmt.Println(err)
+		h.logError(r, err, nil)
 		h.writeResponse(w, "error", http.StatusBadRequest)
 
 		return
@@ -225,20 +288,26 @@ func (h handlers) EnableHandler(w http.ResponseWriter, r *http.Request) {
 
 	err = r.ParseForm()
 	if err != nil {
-		fmt.Println(err)
+		h.logError(r, err, nil)
 
 		return
 	}
 
 	user := domain.User{
 		SlackUserID: r.PostForm.Get("user_id"),
+		SlackTeamID: r.PostForm.Get("team_id"),
 		Enabled:     true,
 	}
 
 	err = h.services.UpdateUserEnabledBySlackID(ctx, user)
+	if errors.Is(err, app_error.UserNotFound) {
+		h.writeResponse(w, "Spotify Status isn't set up for you yet, install it first", http.StatusOK)
+
+		return
+	}
 	if err != nil {
 		appError := app_error.RemoveUserError
-		fmt.Println(err, appError)
+		h.logError(r, err, appError)
 		h.writeResponse(w, appError.Error(), appError.Status())
 
 		return
@@ -252,7 +321,7 @@ func (h handlers) DisableHandler(w http.ResponseWriter, r *http.Request) {
 
 	err := h.verifySlackSignature(w, r)
 	if err != nil {
-		fmt.Println(err)
+		h.logError(r, err, nil)
 		h.writeResponse(w, "error", http.StatusBadRequest)
 
 		return
@@ -260,20 +329,26 @@ func (h handlers) DisableHandler(w http.ResponseWriter, r *http.Request) {
 
 	err = r.ParseForm()
 	if err != nil {
-		fmt.Println(err)
+		h.logError(r, err, nil)
 
 		return
 	}
 
 	user := domain.User{
 		SlackUserID: r.PostForm.Get("user_id"),
+		SlackTeamID: r.PostForm.Get("team_id"),
 		Enabled:     false,
 	}
 
 	err = h.services.UpdateUserEnabledBySlackID(ctx, user)
+	if errors.Is(err, app_error.UserNotFound) {
+		h.writeResponse(w, "Spotify Status isn't set up for you yet, install it first", http.StatusOK)
+
+		return
+	}
 	if err != nil {
 		appError := app_error.RemoveUserError
-		fmt.Println(err, appError)
+		h.logError(r, err, appError)
 		h.writeResponse(w, appError.Error(), appError.Status())
 
 		return
@@ -282,6 +357,499 @@ func (h handlers) DisableHandler(w http.ResponseWriter, r *http.Request) {
 	h.writeResponse(w, "Spotify Status has been disabled", http.StatusOK)
 }
 
+func (h handlers) CommandHandler(w http.ResponseWriter, r *http.Request) {
+	err := h.verifySlackSignature(w, r)
+	if err != nil {
+		h.logError(r, err, nil)
+		h.writeResponse(w, "error", http.StatusBadRequest)
+
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		h.logError(r, err, nil)
+
+		return
+	}
+
+	var subcommand string
+	args := strings.Fields(r.PostForm.Get("text"))
+	if len(args) > 0 {
+		subcommand = strings.ToLower(args[0])
+	}
+
+	switch subcommand {
+	case "share":
+		h.shareCommand(w, r)
+	case "history":
+		h.historyCommand(w, r, args[1:])
+	case "digest":
+		h.digestCommand(w, r, args[1:])
+	case "team":
+		h.teamCommand(w, r)
+	case "privacy":
+		h.privacyCommand(w, r, args[1:])
+	case "workspace":
+		h.workspaceCommand(w, r, args[1:])
+	case "charts":
+		h.chartsCommand(w, r, args[1:])
+	case "export":
+		h.exportCommand(w, r)
+	default:
+		h.writeResponse(w, commandUsage, http.StatusOK)
+	}
+}
+
+func (h handlers) shareCommand(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	err := h.services.ShareCurrentTrack(ctx, r.PostForm.Get("user_id"), r.PostForm.Get("channel_id"))
+	if errors.Is(err, app_error.NothingPlaying) {
+		h.writeResponse(w, "Nothing is playing on your Spotify right now", http.StatusOK)
+
+		return
+	}
+	if err != nil {
+		appError := app_error.ShareTrackError
+		h.logError(r, err, appError)
+		h.writeResponse(w, appError.Error(), appError.Status())
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h handlers) exportCommand(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	err := h.services.SendUserExport(ctx, r.PostForm.Get("user_id"))
+	if err != nil {
+		appError := app_error.ExportError
+		h.logError(r, err, appError)
+		h.writeResponse(w, appError.Error(), appError.Status())
+
+		return
+	}
+
+	h.writeResponse(w, "Your data export has been sent to you in a direct message", http.StatusOK)
+}
+
+func (h handlers) historyCommand(w http.ResponseWriter, r *http.Request, args []string) {
+	ctx := r.Context()
+
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		h.writeResponse(w, commandUsage, http.StatusOK)
+
+		return
+	}
+
+	user := domain.User{
+		SlackUserID:      r.PostForm.Get("user_id"),
+		ListeningHistory: args[0] == "on",
+	}
+
+	err := h.services.UpdateUserListeningHistoryBySlackID(ctx, user)
+	if err != nil {
+		appError := app_error.ListeningHistoryError
+		h.logError(r, err, appError)
+		h.writeResponse(w, appError.Error(), appError.Status())
+
+		return
+	}
+
+	if user.ListeningHistory {
+		h.writeResponse(w, "Your listening history will be recorded from now on", http.StatusOK)
+
+		return
+	}
+
+	h.writeResponse(w, "Your listening history has been disabled and removed", http.StatusOK)
+}
+
+func (h handlers) digestCommand(w http.ResponseWriter, r *http.Request, args []string) {
+	ctx := r.Context()
+
+	if len(args) == 1 && (args[0] == "on" || args[0] == "off") {
+		user := domain.User{
+			SlackUserID:  r.PostForm.Get("user_id"),
+			WeeklyDigest: args[0] == "on",
+		}
+
+		err := h.services.UpdateUserWeeklyDigestBySlackID(ctx, user)
+		if err != nil {
+			appError := app_error.DigestError
+			h.logError(r, err, appError)
+			h.writeResponse(w, appError.Error(), appError.Status())
+
+			return
+		}
+
+		if user.WeeklyDigest {
+			h.writeResponse(w, "You will receive a weekly listening digest while your listening history is on", http.StatusOK)
+
+			return
+		}
+
+		h.writeResponse(w, "You will no longer receive the weekly listening digest", http.StatusOK)
+
+		return
+	}
+
+	if len(args) != 2 && len(args) != 3 {
+		h.writeResponse(w, commandUsage, http.StatusOK)
+
+		return
+	}
+
+	weekday, ok := parseWeekday(args[0])
+	if !ok {
+		h.writeResponse(w, commandUsage, http.StatusOK)
+
+		return
+	}
+
+	digestTime, err := time.Parse("15:04", args[1])
+	if err != nil {
+		h.writeResponse(w, commandUsage, http.StatusOK)
+
+		return
+	}
+
+	timezone := "UTC"
+	if len(args) == 3 {
+		timezone = args[2]
+	}
+
+	user := domain.User{
+		SlackUserID:   r.PostForm.Get("user_id"),
+		DigestWeekday: weekday,
+		DigestMinute:  digestTime.Hour()*60 + digestTime.Minute(),
+		Timezone:      timezone,
+	}
+
+	err = h.services.UpdateUserDigestScheduleBySlackID(ctx, user)
+	if errors.Is(err, app_error.InvalidDigestSchedule) {
+		h.writeResponse(w, "Unknown timezone, use a name like America/Sao_Paulo", http.StatusOK)
+
+		return
+	}
+	if err != nil {
+		appError := app_error.DigestError
+		h.logError(r, err, appError)
+		h.writeResponse(w, appError.Error(), appError.Status())
+
+		return
+	}
+
+	h.writeResponse(w, fmt.Sprintf("Your weekly digest will be sent on %s at %s (%s)", weekday, args[1], timezone), http.StatusOK)
+}
+
+func (h handlers) teamCommand(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	users, err := h.services.SearchTeamNowPlaying(ctx, r.PostForm.Get("team_id"))
+	if errors.Is(err, app_error.TeamViewDisabled) {
+		h.writeResponse(w, "The team view is disabled in this workspace", http.StatusOK)
+
+		return
+	}
+	if err != nil {
+		appError := app_error.TeamViewError
+		h.logError(r, err, appError)
+		h.writeResponse(w, appError.Error(), appError.Status())
+
+		return
+	}
+
+	if len(users) == 0 {
+		h.writeResponse(w, "Nobody is sharing what they are listening to right now", http.StatusOK)
+
+		return
+	}
+
+	lines := make([]string, len(users))
+	for i, user := range users {
+		lines[i] = fmt.Sprintf("• <@%s>: %s - %s", user.SlackUserID, user.NowPlayingTrack, user.NowPlayingArtists)
+	}
+
+	h.writeResponse(w, strings.Join(lines, "\n"), http.StatusOK)
+}
+
+func (h handlers) privacyCommand(w http.ResponseWriter, r *http.Request, args []string) {
+	ctx := r.Context()
+
+	if len(args) != 1 || (args[0] != "public" && args[0] != "private") {
+		h.writeResponse(w, commandUsage, http.StatusOK)
+
+		return
+	}
+
+	user := domain.User{
+		SlackUserID: r.PostForm.Get("user_id"),
+		TeamVisible: args[0] == "public",
+	}
+
+	err := h.services.UpdateUserTeamVisibleBySlackID(ctx, user)
+	if err != nil {
+		appError := app_error.TeamViewError
+		h.logError(r, err, appError)
+		h.writeResponse(w, appError.Error(), appError.Status())
+
+		return
+	}
+
+	if user.TeamVisible {
+		h.writeResponse(w, "What you are listening to is now visible in the team view", http.StatusOK)
+
+		return
+	}
+
+	h.writeResponse(w, "What you are listening to is now hidden from the team view", http.StatusOK)
+}
+
+func (h handlers) workspaceCommand(w http.ResponseWriter, r *http.Request, args []string) {
+	ctx := r.Context()
+
+	isAdmin, err := h.services.IsWorkspaceAdmin(ctx, r.PostForm.Get("user_id"), r.PostForm.Get("team_id"))
+	if err != nil {
+		appError := app_error.WorkspaceSettingsError
+		h.logError(r, err, appError)
+		h.writeResponse(w, appError.Error(), appError.Status())
+
+		return
+	}
+	if !isAdmin {
+		appError := app_error.NotWorkspaceAdmin
+		h.writeResponse(w, appError.Error(), appError.Status())
+
+		return
+	}
+
+	if len(args) > 0 && args[0] == "charts" {
+		h.workspaceChartsCommand(w, r, args[1:])
+
+		return
+	}
+
+	if len(args) > 0 && args[0] == "audit" {
+		h.workspaceAuditCommand(w, r, args[1:])
+
+		return
+	}
+
+	if len(args) != 2 || args[0] != "team-view" || (args[1] != "on" && args[1] != "off") {
+		h.writeResponse(w, commandUsage, http.StatusOK)
+
+		return
+	}
+
+	workspace := domain.Workspace{
+		SlackTeamID:   r.PostForm.Get("team_id"),
+		AllowTeamView: args[1] == "on",
+	}
+
+	err = h.services.UpdateWorkspaceTeamView(ctx, workspace)
+	if err != nil {
+		appError := app_error.WorkspaceSettingsError
+		h.logError(r, err, appError)
+		h.writeResponse(w, appError.Error(), appError.Status())
+
+		return
+	}
+
+	h.writeResponse(w, "Team view has been turned "+args[1]+" for this workspace", http.StatusOK)
+}
+
+func (h handlers) workspaceChartsCommand(w http.ResponseWriter, r *http.Request, args []string) {
+	ctx := r.Context()
+
+	workspace := domain.Workspace{
+		SlackTeamID: r.PostForm.Get("team_id"),
+	}
+
+	switch {
+	case len(args) == 1 && args[0] == "off":
+	case len(args) == 2 && (args[1] == domain.ChartsPeriodWeek || args[1] == domain.ChartsPeriodMonth):
+		workspace.ChartsChannel = parseChannelID(args[0])
+		workspace.ChartsPeriod = args[1]
+	default:
+		h.writeResponse(w, commandUsage, http.StatusOK)
+
+		return
+	}
+
+	err := h.services.UpdateWorkspaceCharts(ctx, workspace)
+	if err != nil {
+		appError := app_error.WorkspaceSettingsError
+		h.logError(r, err, appError)
+		h.writeResponse(w, appError.Error(), appError.Status())
+
+		return
+	}
+
+	if workspace.ChartsChannel == "" {
+		h.writeResponse(w, "Charts will no longer be posted for this workspace", http.StatusOK)
+
+		return
+	}
+
+	h.writeResponse(w, fmt.Sprintf("Charts will be posted to <#%s> every %s", workspace.ChartsChannel, workspace.ChartsPeriod), http.StatusOK)
+}
+
+func (h handlers) workspaceAuditCommand(w http.ResponseWriter, r *http.Request, args []string) {
+	ctx := r.Context()
+
+	if len(args) > 1 {
+		h.writeResponse(w, commandUsage, http.StatusOK)
+
+		return
+	}
+
+	filter := domain.AuditEventFilter{
+		SlackTeamID: r.PostForm.Get("team_id"),
+	}
+	if len(args) == 1 {
+		filter.SlackUserID = parseUserID(args[0])
+	}
+
+	events, err := h.services.SearchAuditEvents(ctx, filter)
+	if err != nil {
+		appError := app_error.AuditError
+		h.logError(r, err, appError)
+		h.writeResponse(w, appError.Error(), appError.Status())
+
+		return
+	}
+
+	if len(events) == 0 {
+		h.writeResponse(w, "No audit events recorded", http.StatusOK)
+
+		return
+	}
+
+	lines := make([]string, len(events))
+	for i, event := range events {
+		actor := event.Actor
+		if actor != domain.AuditActorApp && actor != domain.AuditActorAdmin {
+			actor = "<@" + actor + ">"
+		}
+
+		lines[i] = fmt.Sprintf("%s <@%s> %s by %s", event.CreatedAt.UTC().Format("2006-01-02 15:04 MST"), event.SlackUserID, event.Action, actor)
+		if event.Detail != "" {
+			lines[i] += ": " + event.Detail
+		}
+	}
+
+	h.writeResponse(w, "Latest audit events, newest first\n"+strings.Join(lines, "\n"), http.StatusOK)
+}
+
+func (h handlers) chartsCommand(w http.ResponseWriter, r *http.Request, args []string) {
+	ctx := r.Context()
+
+	period := domain.ChartsPeriodWeek
+	if len(args) > 0 {
+		period = args[0]
+	}
+
+	if period != domain.ChartsPeriodWeek && period != domain.ChartsPeriodMonth {
+		h.writeResponse(w, commandUsage, http.StatusOK)
+
+		return
+	}
+
+	summary, err := h.services.SearchWorkspaceCharts(ctx, r.PostForm.Get("team_id"), period)
+	if errors.Is(err, app_error.NotEnoughListeners) {
+		h.writeResponse(w, "There are not enough listeners in this workspace to show charts yet", http.StatusOK)
+
+		return
+	}
+	if err != nil {
+		appError := app_error.ChartsError
+		h.logError(r, err, appError)
+		h.writeResponse(w, appError.Error(), appError.Status())
+
+		return
+	}
+
+	charts := append([]string{fmt.Sprintf("Charts for the last %s (%d listeners)", period, summary.Listeners)}, services.ChartsSections(summary)...)
+
+	h.writeResponse(w, strings.Join(charts, "\n\n"), http.StatusOK)
+}
+
+// parseChannelID accepts both a raw channel ID and Slack's escaped channel
+// mention, e.g. <#C0123|general>
+func parseChannelID(value string) string {
+	value = strings.TrimPrefix(value, "<#")
+	value = strings.TrimSuffix(value, ">")
+
+	channelID, _, _ := strings.Cut(value, "|")
+
+	return channelID
+}
+
+// parseUserID accepts both a raw user ID and Slack's escaped user mention,
+// e.g. <@U0123|jane>
+func parseUserID(value string) string {
+	value = strings.TrimPrefix(value, "<@")
+	value = strings.TrimSuffix(value, ">")
+
+	userID, _, _ := strings.Cut(value, "|")
+
+	return userID
+}
+
+func (h handlers) InteractivityHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	err := h.verifySlackSignature(w, r)
+	if err != nil {
+		h.logError(r, err, nil)
+		h.writeResponse(w, "error", http.StatusBadRequest)
+
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		h.logError(r, err, nil)
+
+		return
+	}
+
+	var payload slack.InteractionCallback
+	err = json.Unmarshal([]byte(r.PostForm.Get("payload")), &payload)
+	if err != nil {
+		h.logError(r, err, nil)
+		h.writeResponse(w, "error", http.StatusBadRequest)
+
+		return
+	}
+
+	if payload.Type != slack.InteractionTypeMessageAction || payload.CallbackID != shareShortcutCallbackID {
+		h.writeResponse(w, "unknown interaction", http.StatusBadRequest)
+
+		return
+	}
+
+	err = h.services.ShareCurrentTrack(ctx, payload.User.ID, payload.Channel.ID)
+	if errors.Is(err, app_error.NothingPlaying) {
+		h.writeResponse(w, "Nothing is playing on your Spotify right now", http.StatusOK)
+
+		return
+	}
+	if err != nil {
+		appError := app_error.ShareTrackError
+		h.logError(r, err, appError)
+		h.writeResponse(w, appError.Error(), appError.Status())
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
 func (h handlers) verifySlackSignature(w http.ResponseWriter, r *http.Request) error {
 	slackTimestamp := r.Header.Get("X-Slack-Request-Timestamp")
 
@@ -323,18 +891,25 @@ func (h handlers) verifySlackSignature(w http.ResponseWriter, r *http.Request) e
 	return nil
 }
 
+func parseWeekday(value string) (time.Weekday, bool) {
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		name := strings.ToLower(weekday.String())
+		if strings.ToLower(value) == name || strings.ToLower(value) == name[:3] {
+			return weekday, true
+		}
+	}
+
+	return time.Sunday, false
+}
+
 func (h handlers) writeResponse(w http.ResponseWriter, resp interface{}, status int) {
 	w.WriteHeader(status)
 	w.Header().Set("Content-Type", "application/json")
 	jsonResp, err := json.Marshal(resp)
 	if err != nil {
-		log.Fatalf("Error happened in JSON marshal. Err: %s", err)
+		h.logger.Error("encoding the response failed", "error", err)
+		return
 	}
 
 	w.Write(jsonResp)
 }
-
-func (h handlers) HealthHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("New Relic ok")
-	fmt.Fprintf(w, "OK")
-}
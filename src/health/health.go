@@ -0,0 +1,124 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusOK      = "ok"
+	StatusFailing = "failing"
+)
+
+// Check reports why a dependency isn't ready, or nil when it is
+type Check func(ctx context.Context) error
+
+type checkResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type response struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+// Handler runs every check concurrently, each bounded by timeout, and
+// answers 200 when all of them pass or 503 otherwise, with the status of
+// each check. Without checks it only tells the process is serving.
+func Handler(timeout time.Duration, checks map[string]Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		resp := response{Status: StatusOK}
+		if len(checks) > 0 {
+			resp.Checks = make(map[string]checkResult, len(checks))
+		}
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for name, check := range checks {
+			name, check := name, check
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				result := checkResult{Status: StatusOK}
+				if err := check(ctx); err != nil {
+					result = checkResult{Status: StatusFailing, Error: err.Error()}
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				resp.Checks[name] = result
+				if result.Status != StatusOK {
+					resp.Status = StatusFailing
+				}
+			}()
+		}
+		wg.Wait()
+
+		status := http.StatusOK
+		if resp.Status != StatusOK {
+			status = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	})
+}
+
+// Heartbeat records when a recurring task last succeeded
+type Heartbeat struct {
+	last atomic.Int64
+}
+
+// NewHeartbeat starts beating now, giving the task until its first run
+func NewHeartbeat() *Heartbeat {
+	heartbeat := &Heartbeat{}
+	heartbeat.Beat()
+	return heartbeat
+}
+
+func (h *Heartbeat) Beat() {
+	h.last.Store(time.Now().UnixNano())
+}
+
+// Check fails once the last beat is older than maxAge
+func (h *Heartbeat) Check(maxAge time.Duration) Check {
+	return func(ctx context.Context) error {
+		age := time.Since(time.Unix(0, h.last.Load()))
+		if age > maxAge {
+			return fmt.Errorf("last succeeded %s ago", age.Round(time.Second))
+		}
+
+		return nil
+	}
+}
+
+// Running tracks whether a component is up, like the scheduler between its
+// start and its shutdown
+type Running struct {
+	running atomic.Bool
+}
+
+func (r *Running) Set(running bool) {
+	r.running.Store(running)
+}
+
+func (r *Running) Check(ctx context.Context) error {
+	if !r.running.Load() {
+		return errors.New("not running")
+	}
+
+	return nil
+}
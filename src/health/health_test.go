@@ -0,0 +1,79 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func serve(t *testing.T, handler http.Handler) (int, response) {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var resp response
+	if err := json.NewDecoder(recorder.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding the response: %s", err)
+	}
+
+	return recorder.Code, resp
+}
+
+func TestHandler(t *testing.T) {
+	status, resp := serve(t, Handler(time.Second, nil))
+	if status != http.StatusOK || resp.Status != StatusOK || resp.Checks != nil {
+		t.Errorf("Handler without checks = %d %+v, want 200 ok", status, resp)
+	}
+
+	passing := func(ctx context.Context) error { return nil }
+	failing := func(ctx context.Context) error { return errors.New("unreachable") }
+
+	status, resp = serve(t, Handler(time.Second, map[string]Check{"database": passing, "poll": failing}))
+	if status != http.StatusServiceUnavailable || resp.Status != StatusFailing {
+		t.Errorf("Handler with a failing check = %d %s, want 503 failing", status, resp.Status)
+	}
+	if resp.Checks["database"] != (checkResult{Status: StatusOK}) {
+		t.Errorf("database check = %+v, want ok", resp.Checks["database"])
+	}
+	if resp.Checks["poll"] != (checkResult{Status: StatusFailing, Error: "unreachable"}) {
+		t.Errorf("poll check = %+v, want failing with its error", resp.Checks["poll"])
+	}
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	slow := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	status, resp := serve(t, Handler(10*time.Millisecond, map[string]Check{"database": slow}))
+	if status != http.StatusServiceUnavailable || resp.Checks["database"].Error != context.DeadlineExceeded.Error() {
+		t.Errorf("Handler with a slow check = %d %+v, want it timed out", status, resp)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	heartbeat := NewHeartbeat()
+	if err := heartbeat.Check(time.Minute)(context.Background()); err != nil {
+		t.Errorf("Check of a new heartbeat: %s", err)
+	}
+
+	heartbeat.last.Store(time.Now().Add(-2 * time.Minute).UnixNano())
+	if err := heartbeat.Check(time.Minute)(context.Background()); err == nil {
+		t.Error("Check of a stale heartbeat succeeded")
+	}
+
+	var running Running
+	if err := running.Check(context.Background()); err == nil {
+		t.Error("Check of a component never started succeeded")
+	}
+	running.Set(true)
+	if err := running.Check(context.Background()); err != nil {
+		t.Errorf("Check of a running component: %s", err)
+	}
+}
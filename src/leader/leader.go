@@ -0,0 +1,100 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/o-mago/spotify-status/src/app_error"
+	"github.com/o-mago/spotify-status/src/domain"
+	"github.com/o-mago/spotify-status/src/repositories"
+)
+
+type elector struct {
+	repositories repositories.Repositories
+	name         string
+	holder       string
+	ttl          time.Duration
+	logger       *slog.Logger
+	// Unix nanoseconds until which this replica may act as the leader
+	leaderUntil *atomic.Int64
+}
+
+// Elector campaigns for a lease so only one replica at a time is the
+// leader. A leader renews its lease every third of the TTL and steps down a
+// heartbeat before it could expire, so a replica that loses the database
+// stops before another one takes over.
+type Elector interface {
+	Run(ctx context.Context)
+	IsLeader() bool
+}
+
+func NewElector(repositories repositories.Repositories, name, holder string, ttl time.Duration, logger *slog.Logger) Elector {
+	return elector{
+		repositories: repositories,
+		name:         name,
+		holder:       holder,
+		ttl:          ttl,
+		logger:       logger,
+		leaderUntil:  &atomic.Int64{},
+	}
+}
+
+// Run campaigns until ctx is done, then releases the lease if held so
+// another replica can take over right away
+func (e elector) Run(ctx context.Context) {
+	heartbeat := e.ttl / 3
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		e.campaign(ctx, heartbeat)
+
+		select {
+		case <-ctx.Done():
+			e.leaderUntil.Store(0)
+
+			releaseCtx, cancel := context.WithTimeout(context.Background(), heartbeat)
+			defer cancel()
+
+			err := e.repositories.ReleaseLease(releaseCtx, e.name, e.holder)
+			if err != nil {
+				e.logger.ErrorContext(releaseCtx, "releasing the lease failed", "lease", e.name, "error", err)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e elector) IsLeader() bool {
+	return time.Now().UnixNano() < e.leaderUntil.Load()
+}
+
+func (e elector) campaign(ctx context.Context, heartbeat time.Duration) {
+	now := time.Now()
+
+	err := e.repositories.AcquireLease(ctx, domain.Lease{
+		Name:      e.name,
+		Holder:    e.holder,
+		ExpiresAt: now.Add(e.ttl),
+	}, now)
+	if errors.Is(err, app_error.LeaseHeld) {
+		e.leaderUntil.Store(0)
+		return
+	}
+	// On other errors the lease may still be ours, so leadership lasts
+	// until the previous renewal runs out
+	if err != nil {
+		e.logger.ErrorContext(ctx, "renewing the lease failed", "lease", e.name, "error", err)
+		return
+	}
+
+	if !e.IsLeader() {
+		e.logger.InfoContext(ctx, "became the leader", "lease", e.name, "holder", e.holder)
+	}
+	e.leaderUntil.Store(now.Add(e.ttl - heartbeat).UnixNano())
+}
@@ -0,0 +1,75 @@
+package lifecycle
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+type hook struct {
+	name     string
+	shutdown func(ctx context.Context) error
+}
+
+type manager struct {
+	mu     *sync.Mutex
+	hooks  *[]hook
+	logger *slog.Logger
+}
+
+// Manager shuts the app's components down in the reverse order they were
+// registered, like deferred calls, so each one stops before whatever it
+// depends on
+type Manager interface {
+	OnShutdown(name string, shutdown func(ctx context.Context) error)
+	Wait(timeout time.Duration, signals ...os.Signal)
+	Shutdown(timeout time.Duration)
+}
+
+func NewManager(logger *slog.Logger) Manager {
+	return manager{
+		mu:     &sync.Mutex{},
+		hooks:  &[]hook{},
+		logger: logger,
+	}
+}
+
+func (m manager) OnShutdown(name string, shutdown func(ctx context.Context) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	*m.hooks = append(*m.hooks, hook{name, shutdown})
+}
+
+// Wait blocks until one of signals is received, then shuts down
+func (m manager) Wait(timeout time.Duration, signals ...os.Signal) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, signals...)
+	defer signal.Stop(ch)
+
+	<-ch
+
+	m.Shutdown(timeout)
+}
+
+// Shutdown runs every hook, all of them sharing timeout. A failing hook
+// doesn't keep the following ones from running.
+func (m manager) Shutdown(timeout time.Duration) {
+	m.mu.Lock()
+	hooks := *m.hooks
+	*m.hooks = nil
+	m.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for i := len(hooks) - 1; i >= 0; i-- {
+		err := hooks[i].shutdown(ctx)
+		if err != nil {
+			m.logger.Error("shutting down failed", "component", hooks[i].name, "error", err)
+		}
+	}
+}
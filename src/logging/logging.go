@@ -0,0 +1,93 @@
+package logging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Attributes whose key contains any of these are never written
+var secretKeys = []string{"token", "secret", "password", "authorization", "cookie", "crypto_key", "license"}
+
+// Slack tokens are recognizable, so they're masked wherever they show up,
+// error messages included
+var slackToken = regexp.MustCompile(`xox[a-z]-[0-9A-Za-z-]+`)
+
+const redacted = "[redacted]"
+
+// New returns a logger writing to w in format, text or json, from level
+// debug, info, warn or error on
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	var slogLevel slog.Level
+	err := slogLevel.UnmarshalText([]byte(level))
+	if err != nil {
+		return nil, err
+	}
+
+	options := &slog.HandlerOptions{Level: slogLevel, ReplaceAttr: redact}
+
+	switch format {
+	case FormatText:
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+func redact(groups []string, attr slog.Attr) slog.Attr {
+	key := strings.ToLower(attr.Key)
+	for _, secretKey := range secretKeys {
+		if strings.Contains(key, secretKey) {
+			return slog.String(attr.Key, redacted)
+		}
+	}
+
+	switch value := attr.Value.Any().(type) {
+	case string:
+		return slog.String(attr.Key, slackToken.ReplaceAllString(value, redacted))
+	case error:
+		return slog.String(attr.Key, slackToken.ReplaceAllString(value.Error(), redacted))
+	}
+
+	return attr
+}
+
+// HashID stands in for a Slack user ID in the logs and traces, so entries of
+// the same user can be correlated without naming them
+func HashID(id string) string {
+	if id == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:6])
+}
+
+type contextKey struct{}
+
+// NewContext attaches a logger carrying correlation fields, like a request
+// ID, to ctx
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger attached to ctx, or fallback
+func FromContext(ctx context.Context, fallback *slog.Logger) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+
+	return fallback
+}
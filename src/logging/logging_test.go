@@ -0,0 +1,45 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRedaction(t *testing.T) {
+	var buffer bytes.Buffer
+	logger, err := New(&buffer, "debug", FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Info("message",
+		"slack_access_token", "plain-secret",
+		"Authorization", "Bearer plain-secret",
+		"error", errors.New("invalid_auth for xoxp-1234-abcd"),
+		"detail", "sent xoxb-5678-efgh",
+		"slack_user", HashID("U123"),
+	)
+
+	output := buffer.String()
+	for _, secret := range []string{"plain-secret", "xoxp-1234-abcd", "xoxb-5678-efgh", "U123"} {
+		if strings.Contains(output, secret) {
+			t.Errorf("log shows %q: %s", secret, output)
+		}
+	}
+	for _, want := range []string{`"error":"invalid_auth for [redacted]"`, `"slack_user":"` + HashID("U123") + `"`} {
+		if !strings.Contains(output, want) {
+			t.Errorf("log is missing %s: %s", want, output)
+		}
+	}
+}
+
+func TestNewRejectsUnknownSettings(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, "loud", FormatText); err == nil {
+		t.Error("New with an unknown level succeeded")
+	}
+	if _, err := New(&bytes.Buffer{}, "info", "xml"); err == nil {
+		t.Error("New with an unknown format succeeded")
+	}
+}
@@ -0,0 +1,147 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "spotify_status"
+
+// Outcomes of a user's status update
+const (
+	Updated = "updated"
+	Cleared = "cleared"
+	Skipped = "skipped"
+	Error   = "error"
+)
+
+// Classes of failed status updates
+const (
+	DecryptError = "decrypt"
+	SpotifyError = "spotify"
+	SlackError   = "slack"
+)
+
+var registry = prometheus.NewRegistry()
+
+var (
+	pollTickDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "poll_tick_duration_seconds",
+		Help:      "Time taken to poll every due user of a tick.",
+		Buckets:   []float64{.1, .25, .5, 1, 2.5, 5, 10, 30},
+	})
+	userStatusUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "user_status_updates_total",
+		Help:      "Polled users by outcome, and by class for errors.",
+	}, []string{"outcome", "class"})
+	upstreamRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Latency of Spotify and Slack API requests by status code, which is \"error\" when no response came back.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"api", "code"})
+	spotifyTokenRefreshes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "spotify_token_refreshes_total",
+		Help:      "Spotify access tokens refreshed.",
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		pollTickDuration,
+		userStatusUpdates,
+		upstreamRequestDuration,
+		spotifyTokenRefreshes,
+	)
+}
+
+// Handler serves the metrics, querying the enabled users on each scrape
+func Handler(countEnabledUsers func(ctx context.Context) (int64, error)) http.Handler {
+	gatherers := prometheus.Gatherers{registry, enabledUsersGatherer(countEnabledUsers)}
+
+	return promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{})
+}
+
+func enabledUsersGatherer(countEnabledUsers func(ctx context.Context) (int64, error)) prometheus.Gatherer {
+	enabledUsersRegistry := prometheus.NewRegistry()
+	enabledUsersRegistry.MustRegister(enabledUsersCollector{
+		desc:  prometheus.NewDesc(namespace+"_enabled_users", "Users with status updates enabled.", nil, nil),
+		count: countEnabledUsers,
+	})
+
+	return enabledUsersRegistry
+}
+
+type enabledUsersCollector struct {
+	desc  *prometheus.Desc
+	count func(ctx context.Context) (int64, error)
+}
+
+func (c enabledUsersCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect fails the scrape when the count fails, rather than reporting a
+// made up value
+func (c enabledUsersCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	count, err := c.count(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(count))
+}
+
+func ObservePollTick(duration time.Duration) {
+	pollTickDuration.Observe(duration.Seconds())
+}
+
+// CountUserStatusUpdate records a polled user's outcome, class being empty
+// unless it's an Error
+func CountUserStatusUpdate(outcome, class string) {
+	userStatusUpdates.WithLabelValues(outcome, class).Inc()
+}
+
+func CountSpotifyTokenRefresh() {
+	spotifyTokenRefreshes.Inc()
+}
+
+// InstrumentTransport records the latency and status code of every request
+// sent through base to api
+func InstrumentTransport(api string, base http.RoundTripper) http.RoundTripper {
+	return transport{api, base}
+}
+
+type transport struct {
+	api  string
+	base http.RoundTripper
+}
+
+func (t transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+
+	resp, err := t.base.RoundTrip(req)
+
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	upstreamRequestDuration.WithLabelValues(t.api, code).Observe(time.Since(start).Seconds())
+
+	return resp, err
+}
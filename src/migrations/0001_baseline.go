@@ -0,0 +1,87 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// The schema as AutoMigrate left it, frozen so later changes to db_entities
+// don't alter what this migration creates. Existing databases only get the
+// missing tables and columns.
+
+type baselineUser struct {
+	ID                  string    `gorm:"column:id;primaryKey"`
+	SlackUserID         string    `gorm:"column:slack_user_id"`
+	SlackTeamID         string    `gorm:"column:slack_team_id;index"`
+	SlackAccessToken    string    `gorm:"column:slack_access_token"`
+	SlackBotAccessToken string    `gorm:"column:slack_bot_access_token"`
+	SpotifyAccessToken  string    `gorm:"column:spotify_access_token"`
+	SpotifyRefreshToken string    `gorm:"column:spotify_refresh_token"`
+	SpotifyExpiry       time.Time `gorm:"column:slack_expiry"`
+	SpotifyTokenType    string    `gorm:"column:spotify_token_type"`
+	Enabled             bool      `gorm:"column:enabled"`
+	ListeningHistory    bool      `gorm:"column:listening_history"`
+	WeeklyDigest        bool      `gorm:"column:weekly_digest;default:true"`
+	DigestWeekday       int       `gorm:"column:digest_weekday;default:1"`
+	DigestMinute        int       `gorm:"column:digest_minute;default:540"`
+	Timezone            string    `gorm:"column:timezone;default:UTC"`
+	DigestSentAt        time.Time `gorm:"column:digest_sent_at"`
+	TeamVisible         bool      `gorm:"column:team_visible;default:true"`
+	NowPlayingTrackID   string    `gorm:"column:now_playing_track_id"`
+	NowPlayingTrack     string    `gorm:"column:now_playing_track"`
+	NowPlayingArtists   string    `gorm:"column:now_playing_artists"`
+	NowPlayingUntil     time.Time `gorm:"column:now_playing_until"`
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+}
+
+func (baselineUser) TableName() string {
+	return "users"
+}
+
+type baselineListeningEvent struct {
+	ID          string        `gorm:"column:id;primaryKey"`
+	SlackUserID string        `gorm:"column:slack_user_id;index"`
+	SlackTeamID string        `gorm:"column:slack_team_id;index"`
+	TrackID     string        `gorm:"column:track_id"`
+	TrackName   string        `gorm:"column:track_name"`
+	Artists     []string      `gorm:"column:artists;serializer:json"`
+	Album       string        `gorm:"column:album"`
+	Genres      []string      `gorm:"column:genres;serializer:json"`
+	Duration    time.Duration `gorm:"column:duration"`
+	StartedAt   time.Time     `gorm:"column:started_at;index"`
+	EndedAt     *time.Time    `gorm:"column:ended_at"`
+	CreatedAt   time.Time
+}
+
+func (baselineListeningEvent) TableName() string {
+	return "listening_events"
+}
+
+type baselineWorkspace struct {
+	SlackTeamID   string    `gorm:"column:slack_team_id;primaryKey"`
+	AllowTeamView bool      `gorm:"column:allow_team_view;default:true"`
+	ChartsChannel string    `gorm:"column:charts_channel"`
+	ChartsPeriod  string    `gorm:"column:charts_period"`
+	ChartsSentAt  time.Time `gorm:"column:charts_sent_at"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (baselineWorkspace) TableName() string {
+	return "workspaces"
+}
+
+func init() {
+	register(Migration{
+		Version: 1,
+		Name:    "baseline",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&baselineUser{}, &baselineListeningEvent{}, &baselineWorkspace{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&baselineWorkspace{}, &baselineListeningEvent{}, &baselineUser{})
+		},
+	})
+}
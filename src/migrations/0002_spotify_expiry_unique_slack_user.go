@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+func init() {
+	register(Migration{
+		Version: 2,
+		Name:    "rename_spotify_expiry_unique_slack_user_id",
+		Up: func(tx *gorm.DB) error {
+			err := tx.Exec("ALTER TABLE users RENAME COLUMN slack_expiry TO spotify_expiry").Error
+			if err != nil {
+				return err
+			}
+
+			// CreateUser never checked the Slack ID. Which of a user's rows to
+			// keep is left to the operator, so the migration stops until they
+			// are deduplicated.
+			var duplicates []string
+			err = tx.Raw("SELECT slack_user_id FROM users GROUP BY slack_user_id HAVING COUNT(*) > 1 ORDER BY slack_user_id").
+				Scan(&duplicates).Error
+			if err != nil {
+				return err
+			}
+			if len(duplicates) > 0 {
+				return fmt.Errorf("users has several rows for the Slack users %s, remove the extra rows before migrating", strings.Join(duplicates, ", "))
+			}
+
+			return tx.Exec("CREATE UNIQUE INDEX idx_users_slack_user_id ON users (slack_user_id)").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			err := tx.Exec("DROP INDEX idx_users_slack_user_id").Error
+			if err != nil {
+				return err
+			}
+
+			return tx.Exec("ALTER TABLE users RENAME COLUMN spotify_expiry TO slack_expiry").Error
+		},
+	})
+}
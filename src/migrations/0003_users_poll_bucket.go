@@ -0,0 +1,54 @@
+package migrations
+
+import (
+	"hash/fnv"
+
+	"gorm.io/gorm"
+)
+
+// Frozen copy of domain.PollBuckets and domain.UserPollBucket
+const pollBuckets = 1024
+
+func init() {
+	register(Migration{
+		Version: 3,
+		Name:    "users_poll_bucket",
+		Up: func(tx *gorm.DB) error {
+			err := tx.Exec("ALTER TABLE users ADD COLUMN poll_bucket INTEGER NOT NULL DEFAULT 0").Error
+			if err != nil {
+				return err
+			}
+
+			err = tx.Exec("CREATE INDEX idx_users_poll_bucket ON users (poll_bucket)").Error
+			if err != nil {
+				return err
+			}
+
+			var ids []string
+			err = tx.Table("users").Pluck("id", &ids).Error
+			if err != nil {
+				return err
+			}
+
+			for _, id := range ids {
+				hash := fnv.New32a()
+				hash.Write([]byte(id))
+
+				err = tx.Table("users").Where("id = ?", id).Update("poll_bucket", hash.Sum32()%pollBuckets).Error
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			err := tx.Exec("DROP INDEX idx_users_poll_bucket").Error
+			if err != nil {
+				return err
+			}
+
+			return tx.Exec("ALTER TABLE users DROP COLUMN poll_bucket").Error
+		},
+	})
+}
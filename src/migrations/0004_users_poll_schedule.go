@@ -0,0 +1,54 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type pollScheduleUser struct {
+	NextPollAt   time.Time `gorm:"column:next_poll_at;index"`
+	LastPlayedAt time.Time `gorm:"column:last_played_at"`
+}
+
+func (pollScheduleUser) TableName() string {
+	return "users"
+}
+
+func init() {
+	register(Migration{
+		Version: 4,
+		Name:    "users_poll_schedule",
+		Up: func(tx *gorm.DB) error {
+			for _, field := range []string{"NextPollAt", "LastPlayedAt"} {
+				if err := tx.Migrator().AddColumn(&pollScheduleUser{}, field); err != nil {
+					return err
+				}
+			}
+
+			err := tx.Migrator().CreateIndex(&pollScheduleUser{}, "NextPollAt")
+			if err != nil {
+				return err
+			}
+
+			// The poller only picks users due by next_poll_at, so existing
+			// users are due right away
+			return tx.Table("users").Where("next_poll_at IS NULL").Update("next_poll_at", time.Now()).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropIndex(&pollScheduleUser{}, "NextPollAt"); err != nil {
+				return err
+			}
+
+			// The SQLite migrator rebuilds the table to drop a column, losing
+			// the indexes created by raw SQL
+			for _, column := range []string{"last_played_at", "next_poll_at"} {
+				if err := tx.Exec("ALTER TABLE users DROP COLUMN " + column).Error; err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	})
+}
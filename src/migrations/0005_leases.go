@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type lease struct {
+	Name      string    `gorm:"column:name;primaryKey"`
+	Holder    string    `gorm:"column:holder"`
+	ExpiresAt time.Time `gorm:"column:expires_at"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (lease) TableName() string {
+	return "leases"
+}
+
+func init() {
+	register(Migration{
+		Version: 5,
+		Name:    "leases",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&lease{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&lease{})
+		},
+	})
+}
@@ -0,0 +1,34 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type auditEvent struct {
+	ID          string    `gorm:"column:id;primaryKey"`
+	SlackUserID string    `gorm:"column:slack_user_id;index"`
+	SlackTeamID string    `gorm:"column:slack_team_id;index"`
+	Action      string    `gorm:"column:action"`
+	Actor       string    `gorm:"column:actor"`
+	Detail      string    `gorm:"column:detail"`
+	CreatedAt   time.Time `gorm:"column:created_at;index"`
+}
+
+func (auditEvent) TableName() string {
+	return "audit_events"
+}
+
+func init() {
+	register(Migration{
+		Version: 6,
+		Name:    "audit_events",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&auditEvent{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&auditEvent{})
+		},
+	})
+}
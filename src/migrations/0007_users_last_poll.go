@@ -0,0 +1,48 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type lastPollUser struct {
+	LastPollAt      time.Time `gorm:"column:last_poll_at"`
+	LastPollOutcome string    `gorm:"column:last_poll_outcome;index"`
+	LastPollError   string    `gorm:"column:last_poll_error"`
+}
+
+func (lastPollUser) TableName() string {
+	return "users"
+}
+
+func init() {
+	register(Migration{
+		Version: 7,
+		Name:    "users_last_poll",
+		Up: func(tx *gorm.DB) error {
+			for _, field := range []string{"LastPollAt", "LastPollOutcome", "LastPollError"} {
+				if err := tx.Migrator().AddColumn(&lastPollUser{}, field); err != nil {
+					return err
+				}
+			}
+
+			return tx.Migrator().CreateIndex(&lastPollUser{}, "LastPollOutcome")
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropIndex(&lastPollUser{}, "LastPollOutcome"); err != nil {
+				return err
+			}
+
+			// The SQLite migrator rebuilds the table to drop a column, losing
+			// the indexes created by raw SQL
+			for _, column := range []string{"last_poll_error", "last_poll_outcome", "last_poll_at"} {
+				if err := tx.Exec("ALTER TABLE users DROP COLUMN " + column).Error; err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	})
+}
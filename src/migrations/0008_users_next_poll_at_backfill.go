@@ -0,0 +1,22 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func init() {
+	register(Migration{
+		Version: 8,
+		Name:    "users_next_poll_at_backfill",
+		// Databases migrated before users_poll_schedule backfilled
+		// next_poll_at kept it NULL for existing users, who were never due
+		Up: func(tx *gorm.DB) error {
+			return tx.Table("users").Where("next_poll_at IS NULL").Update("next_poll_at", time.Now()).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return nil
+		},
+	})
+}
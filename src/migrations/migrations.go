@@ -0,0 +1,221 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is a numbered schema change. Up and Down run inside the same
+// transaction that records the version in schema_migrations.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+type Status struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+type schemaMigration struct {
+	Version   int       `gorm:"column:version;primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"column:name"`
+	AppliedAt time.Time `gorm:"column:applied_at"`
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+var registered []Migration
+
+// Replicas starting together would race to apply the same migrations, so on
+// Postgres they take turns holding this advisory lock
+const advisoryLockID = 7_305_218_112
+
+func register(migration Migration) {
+	registered = append(registered, migration)
+	sort.Slice(registered, func(i, j int) bool {
+		return registered[i].Version < registered[j].Version
+	})
+}
+
+// Up applies every pending migration in version order
+func Up(db *gorm.DB, logger *slog.Logger) error {
+	return withLock(db, func(db *gorm.DB) error {
+		return up(db, logger)
+	})
+}
+
+func up(db *gorm.DB, logger *slog.Logger) error {
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range registered {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Up(tx); err != nil {
+				return err
+			}
+
+			return tx.Create(&schemaMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+
+		logger.Info("applied migration", "version", migration.Version, "name", migration.Name)
+	}
+
+	return nil
+}
+
+// Down rolls back the latest steps applied migrations
+func Down(db *gorm.DB, steps int, logger *slog.Logger) error {
+	return withLock(db, func(db *gorm.DB) error {
+		return down(db, steps, logger)
+	})
+}
+
+func down(db *gorm.DB, steps int, logger *slog.Logger) error {
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return err
+	}
+
+	for i := len(registered) - 1; i >= 0 && steps > 0; i-- {
+		migration := registered[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		if migration.Down == nil {
+			return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, errors.New("irreversible migration"))
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Down(tx); err != nil {
+				return err
+			}
+
+			return tx.Delete(&schemaMigration{}, "version = ?", migration.Version).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+
+		logger.Info("rolled back migration", "version", migration.Version, "name", migration.Name)
+		steps--
+	}
+
+	return nil
+}
+
+// Statuses lists every known migration and whether it was applied, without
+// changing the schema. Before the first migration every one is pending.
+func Statuses(db *gorm.DB) ([]Status, error) {
+	applied, err := recordedVersions(db)
+	if err != nil {
+		return []Status{}, err
+	}
+
+	statuses := make([]Status, len(registered))
+	for i, migration := range registered {
+		appliedMigration, ok := applied[migration.Version]
+		statuses[i] = Status{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			Applied:   ok,
+			AppliedAt: appliedMigration.AppliedAt,
+		}
+	}
+
+	return statuses, nil
+}
+
+// CheckUpToDate fails when migrations are pending, without applying them or
+// creating schema_migrations
+func CheckUpToDate(db *gorm.DB) error {
+	applied, err := recordedVersions(db)
+	if err != nil {
+		return err
+	}
+
+	pending := 0
+	for _, migration := range registered {
+		if _, ok := applied[migration.Version]; !ok {
+			pending++
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("the database schema is %d migrations behind, run migrate up first", pending)
+	}
+
+	return nil
+}
+
+// withLock runs migrate holding the advisory lock, on the connection that
+// holds it. SQLite has a single writer and needs none.
+func withLock(db *gorm.DB, migrate func(db *gorm.DB) error) error {
+	if db.Dialector.Name() != "postgres" {
+		return migrate(db)
+	}
+
+	return db.Connection(func(conn *gorm.DB) error {
+		err := conn.Exec("SELECT pg_advisory_lock(?)", advisoryLockID).Error
+		if err != nil {
+			return fmt.Errorf("locking the migrations: %w", err)
+		}
+		defer conn.Exec("SELECT pg_advisory_unlock(?)", advisoryLockID)
+
+		return migrate(conn)
+	})
+}
+
+// appliedVersions creates schema_migrations if needed, for the migrations
+// about to be recorded
+func appliedVersions(db *gorm.DB) (map[int]schemaMigration, error) {
+	err := db.AutoMigrate(&schemaMigration{})
+	if err != nil {
+		return nil, err
+	}
+
+	return recordedVersions(db)
+}
+
+// recordedVersions reads schema_migrations, as empty when it doesn't exist
+func recordedVersions(db *gorm.DB) (map[int]schemaMigration, error) {
+	if !db.Migrator().HasTable(&schemaMigration{}) {
+		return map[int]schemaMigration{}, nil
+	}
+
+	rows := []schemaMigration{}
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]schemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+
+	return applied, nil
+}
@@ -0,0 +1,98 @@
+package migrations_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/o-mago/spotify-status/src/domain"
+	"github.com/o-mago/spotify-status/src/migrations"
+	"github.com/o-mago/spotify-status/src/repositories"
+	"gorm.io/gorm"
+)
+
+func openDatabase(t *testing.T) *gorm.DB {
+	db, err := repositories.OpenDatabase("sqlite://" + filepath.Join(t.TempDir(), "spotify-status.db"))
+	if err != nil {
+		t.Fatalf("open database: %s", err)
+	}
+
+	return db
+}
+
+func TestStatusesLeavesSchemaUntouched(t *testing.T) {
+	db := openDatabase(t)
+
+	statuses, err := migrations.Statuses(db)
+	if err != nil {
+		t.Fatalf("Statuses: %s", err)
+	}
+	for _, status := range statuses {
+		if status.Applied {
+			t.Errorf("migration %d applied on an empty database", status.Version)
+		}
+	}
+
+	if db.Migrator().HasTable("schema_migrations") {
+		t.Error("Statuses created schema_migrations")
+	}
+}
+
+func TestUsersBeforePollScheduleArePolled(t *testing.T) {
+	db := openDatabase(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// Back to before users_poll_schedule, with a user created then
+	if err := migrations.Up(db, logger); err != nil {
+		t.Fatalf("Up: %s", err)
+	}
+	if err := migrations.Down(db, 5, logger); err != nil {
+		t.Fatalf("Down: %s", err)
+	}
+	if err := db.Exec("INSERT INTO users (id, slack_user_id, enabled) VALUES (?, ?, ?)", "user-1", "U1", true).Error; err != nil {
+		t.Fatalf("insert user: %s", err)
+	}
+
+	if err := migrations.Up(db, logger); err != nil {
+		t.Fatalf("Up: %s", err)
+	}
+
+	assertDue(t, db, logger, "U1")
+
+	// Databases that applied users_poll_schedule before its backfill
+	if err := migrations.Down(db, 1, logger); err != nil {
+		t.Fatalf("Down: %s", err)
+	}
+	if err := db.Exec("UPDATE users SET next_poll_at = NULL").Error; err != nil {
+		t.Fatalf("clear next_poll_at: %s", err)
+	}
+	if err := migrations.Up(db, logger); err != nil {
+		t.Fatalf("Up: %s", err)
+	}
+
+	assertDue(t, db, logger, "U1")
+}
+
+func assertDue(t *testing.T, db *gorm.DB, logger *slog.Logger, want string) {
+	t.Helper()
+
+	ctx := context.Background()
+	repo := repositories.NewRepository(db, 5*time.Second, logger)
+
+	var due []string
+	err := repo.SearchUsersInBatches(ctx, domain.UserPollFilter{DueAt: time.Now()}, 10, func(users []domain.User) error {
+		for _, user := range users {
+			due = append(due, user.SlackUserID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("SearchUsersInBatches: %s", err)
+	}
+	if len(due) != 1 || due[0] != want {
+		t.Errorf("due users = %v, want [%s]", due, want)
+	}
+}
@@ -0,0 +1,65 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/o-mago/spotify-status/src/domain"
+	"github.com/o-mago/spotify-status/src/repositories/db_entities"
+)
+
+func (repo repositories) CreateAuditEvent(ctx context.Context, domainEvent domain.AuditEvent) error {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	event := db_entities.NewAuditEventFromDomain(domainEvent)
+	result := db.Create(&event)
+	if result.Error != nil {
+		repo.logQueryError(ctx, result)
+		return result.Error
+	}
+	return nil
+}
+
+func (repo repositories) SearchAuditEvents(ctx context.Context, filter domain.AuditEventFilter) ([]domain.AuditEvent, error) {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	query := db.Model(&db_entities.AuditEvent{})
+	if filter.SlackUserID != "" {
+		query = query.Where("slack_user_id = ?", filter.SlackUserID)
+	}
+	if filter.SlackTeamID != "" {
+		query = query.Where("slack_team_id = ?", filter.SlackTeamID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	events := db_entities.AuditEvents{}
+	if err := query.Order("created_at DESC").Order("id DESC").Find(&events).Error; err != nil {
+		return []domain.AuditEvent{}, err
+	}
+	return events.ToDomain(), nil
+}
+
+func (repo repositories) RemoveAuditEventsBefore(ctx context.Context, before time.Time) (int64, error) {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	result := db.Where("created_at < ?", before).Delete(&db_entities.AuditEvent{})
+	if result.Error != nil {
+		repo.logQueryError(ctx, result)
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,48 @@
+package repositories
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// OpenDatabase picks the driver from the database URL scheme: postgres:// or
+// postgresql:// for Postgres and sqlite:// (e.g. sqlite://./spotify-status.db
+// or sqlite://:memory:) for SQLite. URLs without a scheme, like libpq's
+// "host=... user=..." DSNs, are Postgres.
+func OpenDatabase(databaseURL string) (*gorm.DB, error) {
+	scheme, _, found := strings.Cut(databaseURL, "://")
+	if !found {
+		scheme = ""
+	}
+
+	config := &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	}
+
+	switch scheme {
+	case "", "postgres", "postgresql":
+		return gorm.Open(postgres.Open(databaseURL), config)
+	case "sqlite":
+		db, err := gorm.Open(sqlite.Open(strings.TrimPrefix(databaseURL, "sqlite://")), config)
+		if err != nil {
+			return nil, err
+		}
+
+		// SQLite allows a single writer, so the poller goroutines share
+		// one connection instead of failing with "database is locked"
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		sqlDB.SetMaxOpenConns(1)
+
+		return db, nil
+	default:
+		return nil, fmt.Errorf("unsupported database scheme %q", scheme)
+	}
+}
@@ -0,0 +1,51 @@
+package db_entities
+
+import (
+	"time"
+
+	"github.com/o-mago/spotify-status/src/domain"
+)
+
+type AuditEvent struct {
+	ID          string    `gorm:"column:id;primaryKey"`
+	SlackUserID string    `gorm:"column:slack_user_id;index"`
+	SlackTeamID string    `gorm:"column:slack_team_id;index"`
+	Action      string    `gorm:"column:action"`
+	Actor       string    `gorm:"column:actor"`
+	Detail      string    `gorm:"column:detail"`
+	CreatedAt   time.Time `gorm:"column:created_at;index"`
+}
+
+func (event AuditEvent) ToDomain() domain.AuditEvent {
+	return domain.AuditEvent{
+		ID:          event.ID,
+		SlackUserID: event.SlackUserID,
+		SlackTeamID: event.SlackTeamID,
+		Action:      event.Action,
+		Actor:       event.Actor,
+		Detail:      event.Detail,
+		CreatedAt:   event.CreatedAt,
+	}
+}
+
+func NewAuditEventFromDomain(event domain.AuditEvent) AuditEvent {
+	return AuditEvent{
+		ID:          event.ID,
+		SlackUserID: event.SlackUserID,
+		SlackTeamID: event.SlackTeamID,
+		Action:      event.Action,
+		Actor:       event.Actor,
+		Detail:      event.Detail,
+		CreatedAt:   event.CreatedAt,
+	}
+}
+
+type AuditEvents []AuditEvent
+
+func (e AuditEvents) ToDomain() []domain.AuditEvent {
+	a := make([]domain.AuditEvent, len(e))
+	for i := range e {
+		a[i] = e[i].ToDomain()
+	}
+	return a
+}
@@ -0,0 +1,31 @@
+package db_entities
+
+import (
+	"time"
+
+	"github.com/o-mago/spotify-status/src/domain"
+)
+
+type Lease struct {
+	Name      string    `gorm:"column:name;primaryKey"`
+	Holder    string    `gorm:"column:holder"`
+	ExpiresAt time.Time `gorm:"column:expires_at"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (lease Lease) ToDomain() domain.Lease {
+	return domain.Lease{
+		Name:      lease.Name,
+		Holder:    lease.Holder,
+		ExpiresAt: lease.ExpiresAt,
+	}
+}
+
+func NewLeaseFromDomain(lease domain.Lease) Lease {
+	return Lease{
+		Name:      lease.Name,
+		Holder:    lease.Holder,
+		ExpiresAt: lease.ExpiresAt,
+	}
+}
@@ -0,0 +1,74 @@
+package db_entities
+
+import (
+	"time"
+
+	"github.com/o-mago/spotify-status/src/domain"
+)
+
+type ListeningEvent struct {
+	ID          string        `gorm:"column:id;primaryKey"`
+	SlackUserID string        `gorm:"column:slack_user_id;index"`
+	SlackTeamID string        `gorm:"column:slack_team_id;index"`
+	TrackID     string        `gorm:"column:track_id"`
+	TrackName   string        `gorm:"column:track_name"`
+	Artists     []string      `gorm:"column:artists;serializer:json"`
+	Album       string        `gorm:"column:album"`
+	Genres      []string      `gorm:"column:genres;serializer:json"`
+	Duration    time.Duration `gorm:"column:duration"`
+	StartedAt   time.Time     `gorm:"column:started_at;index"`
+	EndedAt     *time.Time    `gorm:"column:ended_at"`
+	CreatedAt   time.Time
+}
+
+func (event ListeningEvent) ToDomain() domain.ListeningEvent {
+	var endedAt time.Time
+	if event.EndedAt != nil {
+		endedAt = *event.EndedAt
+	}
+
+	return domain.ListeningEvent{
+		ID:          event.ID,
+		SlackUserID: event.SlackUserID,
+		SlackTeamID: event.SlackTeamID,
+		TrackID:     event.TrackID,
+		TrackName:   event.TrackName,
+		Artists:     event.Artists,
+		Album:       event.Album,
+		Genres:      event.Genres,
+		Duration:    event.Duration,
+		StartedAt:   event.StartedAt,
+		EndedAt:     endedAt,
+	}
+}
+
+func NewListeningEventFromDomain(event domain.ListeningEvent) ListeningEvent {
+	var endedAt *time.Time
+	if !event.EndedAt.IsZero() {
+		endedAt = &event.EndedAt
+	}
+
+	return ListeningEvent{
+		ID:          event.ID,
+		SlackUserID: event.SlackUserID,
+		SlackTeamID: event.SlackTeamID,
+		TrackID:     event.TrackID,
+		TrackName:   event.TrackName,
+		Artists:     event.Artists,
+		Album:       event.Album,
+		Genres:      event.Genres,
+		Duration:    event.Duration,
+		StartedAt:   event.StartedAt,
+		EndedAt:     endedAt,
+	}
+}
+
+type ListeningEvents []ListeningEvent
+
+func (e ListeningEvents) ToDomain() []domain.ListeningEvent {
+	a := make([]domain.ListeningEvent, len(e))
+	for i := range e {
+		a[i] = e[i].ToDomain()
+	}
+	return a
+}
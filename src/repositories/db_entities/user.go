@@ -8,13 +8,32 @@ import (
 
 type User struct {
 	ID                  string    `gorm:"column:id;primaryKey"`
-	SlackUserID         string    `gorm:"column:slack_user_id"`
+	SlackUserID         string    `gorm:"column:slack_user_id;uniqueIndex"`
+	SlackTeamID         string    `gorm:"column:slack_team_id;index"`
 	SlackAccessToken    string    `gorm:"column:slack_access_token"`
+	SlackBotAccessToken string    `gorm:"column:slack_bot_access_token"`
 	SpotifyAccessToken  string    `gorm:"column:spotify_access_token"`
 	SpotifyRefreshToken string    `gorm:"column:spotify_refresh_token"`
-	SpotifyExpiry       time.Time `gorm:"column:slack_expiry"`
+	SpotifyExpiry       time.Time `gorm:"column:spotify_expiry"`
 	SpotifyTokenType    string    `gorm:"column:spotify_token_type"`
 	Enabled             bool      `gorm:"column:enabled"`
+	ListeningHistory    bool      `gorm:"column:listening_history"`
+	WeeklyDigest        bool      `gorm:"column:weekly_digest;default:true"`
+	DigestWeekday       int       `gorm:"column:digest_weekday;default:1"`
+	DigestMinute        int       `gorm:"column:digest_minute;default:540"`
+	Timezone            string    `gorm:"column:timezone;default:UTC"`
+	DigestSentAt        time.Time `gorm:"column:digest_sent_at"`
+	TeamVisible         bool      `gorm:"column:team_visible;default:true"`
+	NowPlayingTrackID   string    `gorm:"column:now_playing_track_id"`
+	NowPlayingTrack     string    `gorm:"column:now_playing_track"`
+	NowPlayingArtists   string    `gorm:"column:now_playing_artists"`
+	NowPlayingUntil     time.Time `gorm:"column:now_playing_until"`
+	PollBucket          int       `gorm:"column:poll_bucket;index"`
+	NextPollAt          time.Time `gorm:"column:next_poll_at;index"`
+	LastPlayedAt        time.Time `gorm:"column:last_played_at"`
+	LastPollAt          time.Time `gorm:"column:last_poll_at"`
+	LastPollOutcome     string    `gorm:"column:last_poll_outcome;index"`
+	LastPollError       string    `gorm:"column:last_poll_error"`
 	CreatedAt           time.Time
 	UpdatedAt           time.Time
 }
@@ -23,12 +42,32 @@ func (user User) ToDomain() domain.User {
 	return domain.User{
 		ID:                  user.ID,
 		SlackUserID:         user.SlackUserID,
+		SlackTeamID:         user.SlackTeamID,
 		SlackAccessToken:    user.SlackAccessToken,
+		SlackBotAccessToken: user.SlackBotAccessToken,
 		SpotifyAccessToken:  user.SpotifyAccessToken,
 		SpotifyRefreshToken: user.SpotifyRefreshToken,
 		SpotifyExpiry:       user.SpotifyExpiry,
 		SpotifyTokenType:    user.SpotifyTokenType,
 		Enabled:             user.Enabled,
+		ListeningHistory:    user.ListeningHistory,
+		WeeklyDigest:        user.WeeklyDigest,
+		DigestWeekday:       time.Weekday(user.DigestWeekday),
+		DigestMinute:        user.DigestMinute,
+		Timezone:            user.Timezone,
+		DigestSentAt:        user.DigestSentAt,
+		TeamVisible:         user.TeamVisible,
+		NowPlayingTrackID:   user.NowPlayingTrackID,
+		NowPlayingTrack:     user.NowPlayingTrack,
+		NowPlayingArtists:   user.NowPlayingArtists,
+		NowPlayingUntil:     user.NowPlayingUntil,
+		NextPollAt:          user.NextPollAt,
+		LastPlayedAt:        user.LastPlayedAt,
+		LastPollAt:          user.LastPollAt,
+		LastPollOutcome:     user.LastPollOutcome,
+		LastPollError:       user.LastPollError,
+		CreatedAt:           user.CreatedAt,
+		UpdatedAt:           user.UpdatedAt,
 	}
 }
 
@@ -36,12 +75,31 @@ func NewUserFromDomain(user domain.User) User {
 	return User{
 		ID:                  user.ID,
 		SlackUserID:         user.SlackUserID,
+		SlackTeamID:         user.SlackTeamID,
 		SlackAccessToken:    user.SlackAccessToken,
+		SlackBotAccessToken: user.SlackBotAccessToken,
 		SpotifyAccessToken:  user.SpotifyAccessToken,
 		SpotifyRefreshToken: user.SpotifyRefreshToken,
 		SpotifyExpiry:       user.SpotifyExpiry,
 		SpotifyTokenType:    user.SpotifyTokenType,
 		Enabled:             user.Enabled,
+		ListeningHistory:    user.ListeningHistory,
+		WeeklyDigest:        user.WeeklyDigest,
+		DigestWeekday:       int(user.DigestWeekday),
+		DigestMinute:        user.DigestMinute,
+		Timezone:            user.Timezone,
+		DigestSentAt:        user.DigestSentAt,
+		TeamVisible:         user.TeamVisible,
+		NowPlayingTrackID:   user.NowPlayingTrackID,
+		NowPlayingTrack:     user.NowPlayingTrack,
+		NowPlayingArtists:   user.NowPlayingArtists,
+		NowPlayingUntil:     user.NowPlayingUntil,
+		NextPollAt:          user.NextPollAt,
+		LastPlayedAt:        user.LastPlayedAt,
+		LastPollAt:          user.LastPollAt,
+		LastPollOutcome:     user.LastPollOutcome,
+		LastPollError:       user.LastPollError,
+		PollBucket:          domain.UserPollBucket(user.ID),
 	}
 }
 
@@ -0,0 +1,47 @@
+package db_entities
+
+import (
+	"time"
+
+	"github.com/o-mago/spotify-status/src/domain"
+)
+
+type Workspace struct {
+	SlackTeamID   string    `gorm:"column:slack_team_id;primaryKey"`
+	AllowTeamView bool      `gorm:"column:allow_team_view;default:true"`
+	ChartsChannel string    `gorm:"column:charts_channel"`
+	ChartsPeriod  string    `gorm:"column:charts_period"`
+	ChartsSentAt  time.Time `gorm:"column:charts_sent_at"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (workspace Workspace) ToDomain() domain.Workspace {
+	return domain.Workspace{
+		SlackTeamID:   workspace.SlackTeamID,
+		AllowTeamView: workspace.AllowTeamView,
+		ChartsChannel: workspace.ChartsChannel,
+		ChartsPeriod:  workspace.ChartsPeriod,
+		ChartsSentAt:  workspace.ChartsSentAt,
+	}
+}
+
+func NewWorkspaceFromDomain(workspace domain.Workspace) Workspace {
+	return Workspace{
+		SlackTeamID:   workspace.SlackTeamID,
+		AllowTeamView: workspace.AllowTeamView,
+		ChartsChannel: workspace.ChartsChannel,
+		ChartsPeriod:  workspace.ChartsPeriod,
+		ChartsSentAt:  workspace.ChartsSentAt,
+	}
+}
+
+type Workspaces []Workspace
+
+func (w Workspaces) ToDomain() []domain.Workspace {
+	a := make([]domain.Workspace, len(w))
+	for i := range w {
+		a[i] = w[i].ToDomain()
+	}
+	return a
+}
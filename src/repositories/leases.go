@@ -0,0 +1,47 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/o-mago/spotify-status/src/app_error"
+	"github.com/o-mago/spotify-status/src/domain"
+	"github.com/o-mago/spotify-status/src/repositories/db_entities"
+	"gorm.io/gorm/clause"
+)
+
+// AcquireLease takes or renews the lease, which only succeeds when nobody
+// else holds it or their hold expired by now
+func (repo repositories) AcquireLease(ctx context.Context, domainLease domain.Lease, now time.Time) error {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	lease := db_entities.NewLeaseFromDomain(domainLease)
+	result := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"holder", "expires_at", "updated_at"}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "leases.holder = ? OR leases.expires_at <= ?", Vars: []interface{}{lease.Holder, now}},
+		}},
+	}).Create(&lease)
+	if result.Error != nil {
+		repo.logQueryError(ctx, result)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return app_error.LeaseHeld
+	}
+	return nil
+}
+
+func (repo repositories) ReleaseLease(ctx context.Context, name, holder string) error {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	result := db.Where("name = ? AND holder = ?", name, holder).Delete(&db_entities.Lease{})
+	if result.Error != nil {
+		repo.logQueryError(ctx, result)
+		return result.Error
+	}
+	return nil
+}
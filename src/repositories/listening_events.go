@@ -0,0 +1,115 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/o-mago/spotify-status/src/app_error"
+	"github.com/o-mago/spotify-status/src/domain"
+	"github.com/o-mago/spotify-status/src/repositories/db_entities"
+)
+
+func (repo repositories) CreateListeningEvent(ctx context.Context, domainEvent domain.ListeningEvent) error {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	event := db_entities.NewListeningEventFromDomain(domainEvent)
+	result := db.Create(&event)
+	if result.Error != nil {
+		repo.logQueryError(ctx, result)
+		return result.Error
+	}
+	return nil
+}
+
+func (repo repositories) SearchOpenListeningEvent(ctx context.Context, slackID string) (domain.ListeningEvent, error) {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	event := db_entities.ListeningEvent{}
+	result := db.Where("slack_user_id = ? AND ended_at IS NULL", slackID).Order("started_at DESC").Limit(1).Find(&event)
+	if result.Error != nil {
+		repo.logQueryError(ctx, result)
+		return domain.ListeningEvent{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return domain.ListeningEvent{}, app_error.ListeningEventNotFound
+	}
+	return event.ToDomain(), nil
+}
+
+func (repo repositories) SearchListeningEvents(ctx context.Context, filter domain.ListeningEventFilter) ([]domain.ListeningEvent, error) {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	query := db.Model(&db_entities.ListeningEvent{})
+	if filter.SlackUserID != "" {
+		query = query.Where("slack_user_id = ?", filter.SlackUserID)
+	}
+	if filter.SlackTeamID != "" {
+		query = query.Where("slack_team_id = ?", filter.SlackTeamID)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("started_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("started_at < ?", filter.To)
+	}
+
+	// The latest events are picked newest first, then put back in order
+	if filter.Limit > 0 {
+		events := db_entities.ListeningEvents{}
+		if err := query.Order("started_at DESC").Limit(filter.Limit).Find(&events).Error; err != nil {
+			return []domain.ListeningEvent{}, err
+		}
+		for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+			events[i], events[j] = events[j], events[i]
+		}
+		return events.ToDomain(), nil
+	}
+
+	events := db_entities.ListeningEvents{}
+	if err := query.Order("started_at").Find(&events).Error; err != nil {
+		return []domain.ListeningEvent{}, err
+	}
+	return events.ToDomain(), nil
+}
+
+func (repo repositories) EndListeningEvent(ctx context.Context, id string, endedAt time.Time) error {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	result := db.Model(&db_entities.ListeningEvent{}).Where("id = ?", id).Update("ended_at", endedAt)
+	if result.Error != nil {
+		repo.logQueryError(ctx, result)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return app_error.ListeningEventNotFound
+	}
+	return nil
+}
+
+func (repo repositories) RemoveListeningEventsBySlackID(ctx context.Context, slackID string) error {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	result := db.Where("slack_user_id = ?", slackID).Delete(&db_entities.ListeningEvent{})
+	if result.Error != nil {
+		repo.logQueryError(ctx, result)
+		return result.Error
+	}
+	return nil
+}
+
+func (repo repositories) RemoveListeningEventsBefore(ctx context.Context, before time.Time) (int64, error) {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	result := db.Where("started_at < ?", before).Delete(&db_entities.ListeningEvent{})
+	if result.Error != nil {
+		repo.logQueryError(ctx, result)
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,697 @@
+package repositories
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/o-mago/spotify-status/src/app_error"
+	"github.com/o-mago/spotify-status/src/domain"
+)
+
+// memoryRepositories keeps everything in maps, mirroring the database
+// defaults and errors, for tests and local experiments. Like the database,
+// it refuses to run with a canceled context.
+type memoryRepositories struct {
+	mu         *sync.RWMutex
+	users      map[string]domain.User
+	events     map[string]domain.ListeningEvent
+	audit      map[string]domain.AuditEvent
+	workspaces map[string]domain.Workspace
+	leases     map[string]domain.Lease
+}
+
+func NewMemoryRepository() Repositories {
+	return memoryRepositories{
+		mu:         &sync.RWMutex{},
+		users:      map[string]domain.User{},
+		events:     map[string]domain.ListeningEvent{},
+		audit:      map[string]domain.AuditEvent{},
+		workspaces: map[string]domain.Workspace{},
+		leases:     map[string]domain.Lease{},
+	}
+}
+
+func (repo memoryRepositories) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (repo memoryRepositories) CreateUser(ctx context.Context, domainUser domain.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.users[domainUser.SlackUserID]; ok {
+		return app_error.UserAlreadyExists
+	}
+
+	// Same defaults as the users table
+	domainUser.WeeklyDigest = true
+	domainUser.TeamVisible = true
+	if domainUser.DigestWeekday == time.Sunday {
+		domainUser.DigestWeekday = time.Monday
+	}
+	if domainUser.DigestMinute == 0 {
+		domainUser.DigestMinute = 9 * 60
+	}
+	if domainUser.Timezone == "" {
+		domainUser.Timezone = "UTC"
+	}
+	domainUser.CreatedAt = time.Now()
+	domainUser.UpdatedAt = domainUser.CreatedAt
+
+	repo.users[domainUser.SlackUserID] = domainUser
+	return nil
+}
+
+func (repo memoryRepositories) SearchUsers(ctx context.Context) ([]domain.User, error) {
+	if err := ctx.Err(); err != nil {
+		return []domain.User{}, err
+	}
+
+	return repo.filterUsers(func(user domain.User) bool {
+		return user.Enabled
+	}), nil
+}
+
+func (repo memoryRepositories) CountEnabledUsers(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	users := repo.filterUsers(func(user domain.User) bool {
+		return user.Enabled
+	})
+	return int64(len(users)), nil
+}
+
+func (repo memoryRepositories) SearchUsersInBatches(ctx context.Context, filter domain.UserPollFilter, batchSize int, process func(users []domain.User) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	users := repo.filterUsers(func(user domain.User) bool {
+		return (user.Enabled || filter.IncludeDisabled) && filter.Shard.Includes(domain.UserPollBucket(user.ID)) &&
+			(filter.DueAt.IsZero() || !user.NextPollAt.After(filter.DueAt))
+	})
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+
+	for start := 0; start < len(users); start += batchSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		end := start + batchSize
+		if end > len(users) {
+			end = len(users)
+		}
+
+		if err := process(users[start:end]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (repo memoryRepositories) SearchUsersPage(ctx context.Context, filter domain.UserListFilter) ([]domain.User, error) {
+	if err := ctx.Err(); err != nil {
+		return []domain.User{}, err
+	}
+
+	users := repo.filterUsers(func(user domain.User) bool {
+		return user.SlackUserID > filter.AfterSlackUserID &&
+			(filter.SlackTeamID == "" || user.SlackTeamID == filter.SlackTeamID) &&
+			(filter.Enabled == nil || user.Enabled == *filter.Enabled) &&
+			(filter.Failing == nil || (user.LastPollOutcome == domain.PollOutcomeError) == *filter.Failing)
+	})
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].SlackUserID < users[j].SlackUserID
+	})
+
+	if filter.Limit > 0 && len(users) > filter.Limit {
+		users = users[:filter.Limit]
+	}
+	return users, nil
+}
+
+func (repo memoryRepositories) SearchUserBySlackID(ctx context.Context, slackID string) (domain.User, error) {
+	if err := ctx.Err(); err != nil {
+		return domain.User{}, err
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	user, ok := repo.users[slackID]
+	if !ok {
+		return domain.User{}, app_error.UserNotFound
+	}
+	return user, nil
+}
+
+func (repo memoryRepositories) UpdateUserEnabledBySlackID(ctx context.Context, domainUser domain.User) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	changed := false
+	if !repo.updateUser(domainUser.SlackUserID, func(user *domain.User) {
+		changed = user.Enabled != domainUser.Enabled
+		user.Enabled = domainUser.Enabled
+	}) {
+		return false, app_error.UserNotFound
+	}
+	return changed, nil
+}
+
+func (repo memoryRepositories) UpdateUserListeningHistoryBySlackID(ctx context.Context, domainUser domain.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if !repo.updateUser(domainUser.SlackUserID, func(user *domain.User) {
+		user.ListeningHistory = domainUser.ListeningHistory
+	}) {
+		return app_error.UserNotFound
+	}
+	return nil
+}
+
+func (repo memoryRepositories) SearchDigestUsers(ctx context.Context) ([]domain.User, error) {
+	if err := ctx.Err(); err != nil {
+		return []domain.User{}, err
+	}
+
+	return repo.filterUsers(func(user domain.User) bool {
+		return user.Enabled && user.ListeningHistory && user.WeeklyDigest
+	}), nil
+}
+
+func (repo memoryRepositories) UpdateUserWeeklyDigestBySlackID(ctx context.Context, domainUser domain.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if !repo.updateUser(domainUser.SlackUserID, func(user *domain.User) {
+		user.WeeklyDigest = domainUser.WeeklyDigest
+	}) {
+		return app_error.UserNotFound
+	}
+	return nil
+}
+
+func (repo memoryRepositories) UpdateUserDigestScheduleBySlackID(ctx context.Context, domainUser domain.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if !repo.updateUser(domainUser.SlackUserID, func(user *domain.User) {
+		user.DigestWeekday = domainUser.DigestWeekday
+		user.DigestMinute = domainUser.DigestMinute
+		user.Timezone = domainUser.Timezone
+	}) {
+		return app_error.UserNotFound
+	}
+	return nil
+}
+
+func (repo memoryRepositories) UpdateUserDigestSentAtBySlackID(ctx context.Context, slackID string, sentAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repo.updateUser(slackID, func(user *domain.User) {
+		user.DigestSentAt = sentAt
+	})
+	return nil
+}
+
+func (repo memoryRepositories) UpdateUserTeamVisibleBySlackID(ctx context.Context, domainUser domain.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if !repo.updateUser(domainUser.SlackUserID, func(user *domain.User) {
+		user.TeamVisible = domainUser.TeamVisible
+	}) {
+		return app_error.UserNotFound
+	}
+	return nil
+}
+
+func (repo memoryRepositories) UpdateUserSlackTeamIDBySlackID(ctx context.Context, domainUser domain.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if !repo.updateUser(domainUser.SlackUserID, func(user *domain.User) {
+		user.SlackTeamID = domainUser.SlackTeamID
+	}) {
+		return app_error.UserNotFound
+	}
+	return nil
+}
+
+func (repo memoryRepositories) UpdateUserNowPlayingBySlackID(ctx context.Context, domainUser domain.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repo.updateUser(domainUser.SlackUserID, func(user *domain.User) {
+		user.NowPlayingTrackID = domainUser.NowPlayingTrackID
+		user.NowPlayingTrack = domainUser.NowPlayingTrack
+		user.NowPlayingArtists = domainUser.NowPlayingArtists
+		user.NowPlayingUntil = domainUser.NowPlayingUntil
+	})
+	return nil
+}
+
+func (repo memoryRepositories) UpdateUserPollScheduleBySlackID(ctx context.Context, domainUser domain.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repo.updateUser(domainUser.SlackUserID, func(user *domain.User) {
+		user.NextPollAt = domainUser.NextPollAt
+		user.LastPlayedAt = domainUser.LastPlayedAt
+	})
+	return nil
+}
+
+func (repo memoryRepositories) UpdateUserLastPollBySlackID(ctx context.Context, domainUser domain.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repo.updateUser(domainUser.SlackUserID, func(user *domain.User) {
+		user.LastPollAt = domainUser.LastPollAt
+		user.LastPollOutcome = domainUser.LastPollOutcome
+		user.LastPollError = domainUser.LastPollError
+	})
+	return nil
+}
+
+func (repo memoryRepositories) UpdateUserTokensBySlackID(ctx context.Context, domainUser domain.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if !repo.updateUser(domainUser.SlackUserID, func(user *domain.User) {
+		user.SlackAccessToken = domainUser.SlackAccessToken
+		user.SlackBotAccessToken = domainUser.SlackBotAccessToken
+		user.SpotifyAccessToken = domainUser.SpotifyAccessToken
+		user.SpotifyRefreshToken = domainUser.SpotifyRefreshToken
+	}) {
+		return app_error.UserNotFound
+	}
+	return nil
+}
+
+func (repo memoryRepositories) SearchTeamNowPlaying(ctx context.Context, slackTeamID string, at time.Time) ([]domain.User, error) {
+	if err := ctx.Err(); err != nil {
+		return []domain.User{}, err
+	}
+
+	users := repo.filterUsers(func(user domain.User) bool {
+		return user.SlackTeamID == slackTeamID && user.Enabled && user.TeamVisible && user.NowPlayingUntil.After(at)
+	})
+
+	sort.SliceStable(users, func(i, j int) bool {
+		return users[i].NowPlayingTrack < users[j].NowPlayingTrack
+	})
+
+	return users, nil
+}
+
+func (repo memoryRepositories) SearchUsersBySlackTeamID(ctx context.Context, slackTeamID string) ([]domain.User, error) {
+	if err := ctx.Err(); err != nil {
+		return []domain.User{}, err
+	}
+
+	return repo.filterUsers(func(user domain.User) bool {
+		return user.SlackTeamID == slackTeamID
+	}), nil
+}
+
+func (repo memoryRepositories) RemoveUserBySlackID(ctx context.Context, slackID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.users[slackID]; !ok {
+		return app_error.RemoveUserError
+	}
+
+	delete(repo.users, slackID)
+	return nil
+}
+
+func (repo memoryRepositories) SearchWorkspace(ctx context.Context, slackTeamID string) (domain.Workspace, error) {
+	if err := ctx.Err(); err != nil {
+		return domain.Workspace{}, err
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	workspace, ok := repo.workspaces[slackTeamID]
+	if !ok {
+		return domain.Workspace{}, app_error.WorkspaceNotFound
+	}
+	return workspace, nil
+}
+
+func (repo memoryRepositories) UpdateWorkspaceTeamView(ctx context.Context, domainWorkspace domain.Workspace) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repo.upsertWorkspace(domainWorkspace.SlackTeamID, func(workspace *domain.Workspace) {
+		workspace.AllowTeamView = domainWorkspace.AllowTeamView
+	})
+	return nil
+}
+
+func (repo memoryRepositories) SearchChartsWorkspaces(ctx context.Context) ([]domain.Workspace, error) {
+	if err := ctx.Err(); err != nil {
+		return []domain.Workspace{}, err
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	workspaces := []domain.Workspace{}
+	for _, workspace := range repo.workspaces {
+		if workspace.ChartsChannel != "" {
+			workspaces = append(workspaces, workspace)
+		}
+	}
+	return workspaces, nil
+}
+
+func (repo memoryRepositories) UpdateWorkspaceCharts(ctx context.Context, domainWorkspace domain.Workspace) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repo.upsertWorkspace(domainWorkspace.SlackTeamID, func(workspace *domain.Workspace) {
+		workspace.ChartsChannel = domainWorkspace.ChartsChannel
+		workspace.ChartsPeriod = domainWorkspace.ChartsPeriod
+	})
+	return nil
+}
+
+func (repo memoryRepositories) UpdateWorkspaceChartsSentAt(ctx context.Context, slackTeamID string, sentAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if workspace, ok := repo.workspaces[slackTeamID]; ok {
+		workspace.ChartsSentAt = sentAt
+		repo.workspaces[slackTeamID] = workspace
+	}
+	return nil
+}
+
+func (repo memoryRepositories) RemoveWorkspace(ctx context.Context, slackTeamID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	delete(repo.workspaces, slackTeamID)
+	return nil
+}
+
+func (repo memoryRepositories) CreateListeningEvent(ctx context.Context, domainEvent domain.ListeningEvent) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.events[domainEvent.ID] = copyListeningEvent(domainEvent)
+	return nil
+}
+
+func (repo memoryRepositories) SearchOpenListeningEvent(ctx context.Context, slackID string) (domain.ListeningEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return domain.ListeningEvent{}, err
+	}
+
+	events := repo.filterListeningEvents(func(event domain.ListeningEvent) bool {
+		return event.SlackUserID == slackID && event.EndedAt.IsZero()
+	})
+
+	if len(events) == 0 {
+		return domain.ListeningEvent{}, app_error.ListeningEventNotFound
+	}
+	return events[len(events)-1], nil
+}
+
+func (repo memoryRepositories) SearchListeningEvents(ctx context.Context, filter domain.ListeningEventFilter) ([]domain.ListeningEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return []domain.ListeningEvent{}, err
+	}
+
+	events := repo.filterListeningEvents(func(event domain.ListeningEvent) bool {
+		return (filter.SlackUserID == "" || event.SlackUserID == filter.SlackUserID) &&
+			(filter.SlackTeamID == "" || event.SlackTeamID == filter.SlackTeamID) &&
+			(filter.From.IsZero() || !event.StartedAt.Before(filter.From)) &&
+			(filter.To.IsZero() || event.StartedAt.Before(filter.To))
+	})
+
+	if filter.Limit > 0 && len(events) > filter.Limit {
+		events = events[len(events)-filter.Limit:]
+	}
+	return events, nil
+}
+
+func (repo memoryRepositories) EndListeningEvent(ctx context.Context, id string, endedAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	event, ok := repo.events[id]
+	if !ok {
+		return app_error.ListeningEventNotFound
+	}
+
+	event.EndedAt = endedAt
+	repo.events[id] = event
+	return nil
+}
+
+func (repo memoryRepositories) RemoveListeningEventsBySlackID(ctx context.Context, slackID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for id, event := range repo.events {
+		if event.SlackUserID == slackID {
+			delete(repo.events, id)
+		}
+	}
+	return nil
+}
+
+func (repo memoryRepositories) RemoveListeningEventsBefore(ctx context.Context, before time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var removed int64
+	for id, event := range repo.events {
+		if event.StartedAt.Before(before) {
+			delete(repo.events, id)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+func (repo memoryRepositories) CreateAuditEvent(ctx context.Context, domainEvent domain.AuditEvent) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.audit[domainEvent.ID] = domainEvent
+	return nil
+}
+
+func (repo memoryRepositories) SearchAuditEvents(ctx context.Context, filter domain.AuditEventFilter) ([]domain.AuditEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return []domain.AuditEvent{}, err
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	events := []domain.AuditEvent{}
+	for _, event := range repo.audit {
+		if (filter.SlackUserID == "" || event.SlackUserID == filter.SlackUserID) &&
+			(filter.SlackTeamID == "" || event.SlackTeamID == filter.SlackTeamID) &&
+			(filter.Action == "" || event.Action == filter.Action) &&
+			(filter.From.IsZero() || !event.CreatedAt.Before(filter.From)) &&
+			(filter.To.IsZero() || event.CreatedAt.Before(filter.To)) {
+			events = append(events, event)
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].CreatedAt.Equal(events[j].CreatedAt) {
+			return events[i].CreatedAt.After(events[j].CreatedAt)
+		}
+		return events[i].ID > events[j].ID
+	})
+
+	if filter.Limit > 0 && len(events) > filter.Limit {
+		events = events[:filter.Limit]
+	}
+	return events, nil
+}
+
+func (repo memoryRepositories) RemoveAuditEventsBefore(ctx context.Context, before time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var removed int64
+	for id, event := range repo.audit {
+		if event.CreatedAt.Before(before) {
+			delete(repo.audit, id)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+func (repo memoryRepositories) AcquireLease(ctx context.Context, domainLease domain.Lease, now time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	lease, ok := repo.leases[domainLease.Name]
+	if ok && lease.Holder != domainLease.Holder && lease.ExpiresAt.After(now) {
+		return app_error.LeaseHeld
+	}
+
+	repo.leases[domainLease.Name] = domainLease
+	return nil
+}
+
+func (repo memoryRepositories) ReleaseLease(ctx context.Context, name, holder string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if lease, ok := repo.leases[name]; ok && lease.Holder == holder {
+		delete(repo.leases, name)
+	}
+	return nil
+}
+
+func (repo memoryRepositories) filterUsers(keep func(user domain.User) bool) []domain.User {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	users := []domain.User{}
+	for _, user := range repo.users {
+		if keep(user) {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
+// updateUser applies update to the stored user, reporting whether it exists
+func (repo memoryRepositories) updateUser(slackID string, update func(user *domain.User)) bool {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	user, ok := repo.users[slackID]
+	if !ok {
+		return false
+	}
+
+	update(&user)
+	user.UpdatedAt = time.Now()
+	repo.users[slackID] = user
+	return true
+}
+
+func (repo memoryRepositories) upsertWorkspace(slackTeamID string, update func(workspace *domain.Workspace)) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	workspace, ok := repo.workspaces[slackTeamID]
+	if !ok {
+		workspace = domain.Workspace{SlackTeamID: slackTeamID, AllowTeamView: true}
+	}
+
+	update(&workspace)
+	repo.workspaces[slackTeamID] = workspace
+}
+
+// filterListeningEvents returns copies of the matching events sorted by
+// start time
+func (repo memoryRepositories) filterListeningEvents(keep func(event domain.ListeningEvent) bool) []domain.ListeningEvent {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	events := []domain.ListeningEvent{}
+	for _, event := range repo.events {
+		if keep(event) {
+			events = append(events, copyListeningEvent(event))
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].StartedAt.Before(events[j].StartedAt)
+	})
+	return events
+}
+
+func copyListeningEvent(event domain.ListeningEvent) domain.ListeningEvent {
+	event.Artists = append([]string{}, event.Artists...)
+	event.Genres = append([]string{}, event.Genres...)
+	return event
+}
@@ -2,36 +2,121 @@ package repositories
 
 import (
 	"context"
-	"fmt"
+	"log/slog"
+	"time"
 
 	"github.com/o-mago/spotify-status/src/app_error"
 	"github.com/o-mago/spotify-status/src/domain"
+	"github.com/o-mago/spotify-status/src/logging"
 	"github.com/o-mago/spotify-status/src/repositories/db_entities"
 	"gorm.io/gorm"
 )
 
 type repositories struct {
-	DB *gorm.DB
+	DB           *gorm.DB
+	queryTimeout time.Duration
+	logger       *slog.Logger
 }
 
 type Repositories interface {
+	Ping(ctx context.Context) error
+
 	CreateUser(ctx context.Context, domainUser domain.User) error
 	SearchUsers(ctx context.Context) ([]domain.User, error)
-	UpdateUserEnabledBySlackID(ctx context.Context, domainUser domain.User) error
+	CountEnabledUsers(ctx context.Context) (int64, error)
+	SearchUsersInBatches(ctx context.Context, filter domain.UserPollFilter, batchSize int, process func(users []domain.User) error) error
+	SearchUsersPage(ctx context.Context, filter domain.UserListFilter) ([]domain.User, error)
+	SearchUserBySlackID(ctx context.Context, slackID string) (domain.User, error)
+	UpdateUserEnabledBySlackID(ctx context.Context, domainUser domain.User) (bool, error)
+	UpdateUserListeningHistoryBySlackID(ctx context.Context, domainUser domain.User) error
+	SearchDigestUsers(ctx context.Context) ([]domain.User, error)
+	UpdateUserWeeklyDigestBySlackID(ctx context.Context, domainUser domain.User) error
+	UpdateUserDigestScheduleBySlackID(ctx context.Context, domainUser domain.User) error
+	UpdateUserDigestSentAtBySlackID(ctx context.Context, slackID string, sentAt time.Time) error
+	UpdateUserTeamVisibleBySlackID(ctx context.Context, domainUser domain.User) error
+	UpdateUserSlackTeamIDBySlackID(ctx context.Context, domainUser domain.User) error
+	UpdateUserNowPlayingBySlackID(ctx context.Context, domainUser domain.User) error
+	UpdateUserPollScheduleBySlackID(ctx context.Context, domainUser domain.User) error
+	UpdateUserTokensBySlackID(ctx context.Context, domainUser domain.User) error
+	UpdateUserLastPollBySlackID(ctx context.Context, domainUser domain.User) error
+	SearchTeamNowPlaying(ctx context.Context, slackTeamID string, at time.Time) ([]domain.User, error)
+	SearchUsersBySlackTeamID(ctx context.Context, slackTeamID string) ([]domain.User, error)
 	RemoveUserBySlackID(ctx context.Context, slackID string) error
+
+	SearchWorkspace(ctx context.Context, slackTeamID string) (domain.Workspace, error)
+	UpdateWorkspaceTeamView(ctx context.Context, domainWorkspace domain.Workspace) error
+	SearchChartsWorkspaces(ctx context.Context) ([]domain.Workspace, error)
+	UpdateWorkspaceCharts(ctx context.Context, domainWorkspace domain.Workspace) error
+	UpdateWorkspaceChartsSentAt(ctx context.Context, slackTeamID string, sentAt time.Time) error
+	RemoveWorkspace(ctx context.Context, slackTeamID string) error
+
+	CreateListeningEvent(ctx context.Context, domainEvent domain.ListeningEvent) error
+	SearchOpenListeningEvent(ctx context.Context, slackID string) (domain.ListeningEvent, error)
+	SearchListeningEvents(ctx context.Context, filter domain.ListeningEventFilter) ([]domain.ListeningEvent, error)
+	EndListeningEvent(ctx context.Context, id string, endedAt time.Time) error
+	RemoveListeningEventsBySlackID(ctx context.Context, slackID string) error
+	RemoveListeningEventsBefore(ctx context.Context, before time.Time) (int64, error)
+
+	CreateAuditEvent(ctx context.Context, domainEvent domain.AuditEvent) error
+	SearchAuditEvents(ctx context.Context, filter domain.AuditEventFilter) ([]domain.AuditEvent, error)
+	RemoveAuditEventsBefore(ctx context.Context, before time.Time) (int64, error)
+
+	AcquireLease(ctx context.Context, domainLease domain.Lease, now time.Time) error
+	ReleaseLease(ctx context.Context, name, holder string) error
 }
 
-func NewRepository(db *gorm.DB) Repositories {
+// NewRepository binds every query to the caller's context, bounded by
+// queryTimeout when it is greater than zero
+func NewRepository(db *gorm.DB, queryTimeout time.Duration, logger *slog.Logger) Repositories {
 	return repositories{
-		DB: db,
+		DB:           db,
+		queryTimeout: queryTimeout,
+		logger:       logger,
+	}
+}
+
+// Ping checks the database answers within the query timeout
+func (repo repositories) Ping(ctx context.Context) error {
+	sqlDB, err := repo.DB.DB()
+	if err != nil {
+		return err
+	}
+
+	if repo.queryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, repo.queryTimeout)
+		defer cancel()
 	}
+
+	return sqlDB.PingContext(ctx)
+}
+
+func (repo repositories) withTimeout(ctx context.Context) (*gorm.DB, context.CancelFunc) {
+	if repo.queryTimeout <= 0 {
+		return repo.DB.WithContext(ctx), func() {}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repo.queryTimeout)
+	return repo.DB.WithContext(ctx), cancel
+}
+
+// logQueryError logs the failed statement with placeholders, as its values
+// may be tokens
+func (repo repositories) logQueryError(ctx context.Context, result *gorm.DB) {
+	logging.FromContext(ctx, repo.logger).ErrorContext(ctx, "query failed",
+		"error", result.Error,
+		"sql", result.Statement.SQL.String(),
+	)
 }
 
 func (repo repositories) CreateUser(ctx context.Context, domainUser domain.User) error {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
 	user := db_entities.NewUserFromDomain(domainUser)
-	result := repo.DB.FirstOrCreate(&user)
+	result := db.Where("slack_user_id = ?", user.SlackUserID).Attrs(user).FirstOrCreate(&db_entities.User{})
 	if result.Error != nil {
-		fmt.Println(result.Statement)
+		repo.logQueryError(ctx, result)
 		return result.Error
 	}
 	if result.RowsAffected == 0 {
@@ -41,28 +126,370 @@ func (repo repositories) CreateUser(ctx context.Context, domainUser domain.User)
 }
 
 func (repo repositories) SearchUsers(ctx context.Context) ([]domain.User, error) {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
 	users := db_entities.Users{}
-	if err := repo.DB.Where("enabled = ?", true).Find(&users).Error; err != nil {
+	if err := db.Where("enabled = ?", true).Find(&users).Error; err != nil {
 		return []domain.User{}, err
 	}
 	return users.ToDomain(), nil
 }
 
-func (repo repositories) UpdateUserEnabledBySlackID(ctx context.Context, domainUser domain.User) error {
+func (repo repositories) CountEnabledUsers(ctx context.Context) (int64, error) {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	var count int64
+	if err := db.Model(&db_entities.User{}).Where("enabled = ?", true).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// SearchUsersInBatches pages through the users matching filter ordered by
+// ID, handing each batch to process before the next one is loaded. The
+// query timeout applies per batch, so process isn't bound by it.
+func (repo repositories) SearchUsersInBatches(ctx context.Context, filter domain.UserPollFilter, batchSize int, process func(users []domain.User) error) error {
+	lastID := ""
+	for {
+		users, err := repo.searchUserBatch(ctx, filter, lastID, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			return nil
+		}
+
+		if err := process(users.ToDomain()); err != nil {
+			return err
+		}
+
+		if len(users) < batchSize {
+			return nil
+		}
+		lastID = users[len(users)-1].ID
+	}
+}
+
+func (repo repositories) searchUserBatch(ctx context.Context, filter domain.UserPollFilter, afterID string, batchSize int) (db_entities.Users, error) {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	query := db.Where("id > ?", afterID)
+	if !filter.IncludeDisabled {
+		query = query.Where("enabled = ?", true)
+	}
+	if filter.Shard.Count > 1 {
+		query = query.Where("poll_bucket % ? = ?", filter.Shard.Count, filter.Shard.Index)
+	}
+	if !filter.DueAt.IsZero() {
+		query = query.Where("next_poll_at <= ?", filter.DueAt)
+	}
+
+	users := db_entities.Users{}
+	if err := query.Order("id").Limit(batchSize).Find(&users).Error; err != nil {
+		return db_entities.Users{}, err
+	}
+	return users, nil
+}
+
+func (repo repositories) SearchUsersPage(ctx context.Context, filter domain.UserListFilter) ([]domain.User, error) {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	query := db.Where("slack_user_id > ?", filter.AfterSlackUserID)
+	if filter.SlackTeamID != "" {
+		query = query.Where("slack_team_id = ?", filter.SlackTeamID)
+	}
+	if filter.Enabled != nil {
+		query = query.Where("enabled = ?", *filter.Enabled)
+	}
+	if filter.Failing != nil {
+		if *filter.Failing {
+			query = query.Where("last_poll_outcome = ?", domain.PollOutcomeError)
+		} else {
+			query = query.Where("(last_poll_outcome IS NULL OR last_poll_outcome <> ?)", domain.PollOutcomeError)
+		}
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	users := db_entities.Users{}
+	if err := query.Order("slack_user_id").Find(&users).Error; err != nil {
+		return []domain.User{}, err
+	}
+	return users.ToDomain(), nil
+}
+
+func (repo repositories) SearchUserBySlackID(ctx context.Context, slackID string) (domain.User, error) {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	user := db_entities.User{}
+	result := db.Where("slack_user_id = ?", slackID).Limit(1).Find(&user)
+	if result.Error != nil {
+		repo.logQueryError(ctx, result)
+		return domain.User{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return domain.User{}, app_error.UserNotFound
+	}
+	return user.ToDomain(), nil
+}
+
+// UpdateUserEnabledBySlackID reports whether Enabled flipped, which only
+// one of concurrent updates sees
+func (repo repositories) UpdateUserEnabledBySlackID(ctx context.Context, domainUser domain.User) (bool, error) {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	user := db_entities.NewUserFromDomain(domainUser)
+	result := db.Model(&db_entities.User{}).Where("slack_user_id = ? AND enabled <> ?", user.SlackUserID, user.Enabled).Update("enabled", user.Enabled)
+	if result.Error != nil {
+		repo.logQueryError(ctx, result)
+		return false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return true, nil
+	}
+
+	var count int64
+	if err := db.Model(&db_entities.User{}).Where("slack_user_id = ?", user.SlackUserID).Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count == 0 {
+		return false, app_error.UserNotFound
+	}
+
+	return false, nil
+}
+
+func (repo repositories) UpdateUserListeningHistoryBySlackID(ctx context.Context, domainUser domain.User) error {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	user := db_entities.NewUserFromDomain(domainUser)
+	result := db.Model(&db_entities.User{}).Where("slack_user_id = ?", user.SlackUserID).Update("listening_history", user.ListeningHistory)
+	if result.Error != nil {
+		repo.logQueryError(ctx, result)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return app_error.UserNotFound
+	}
+
+	return nil
+}
+
+func (repo repositories) SearchDigestUsers(ctx context.Context) ([]domain.User, error) {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	users := db_entities.Users{}
+	if err := db.Where("enabled = ? AND listening_history = ? AND weekly_digest = ?", true, true, true).Find(&users).Error; err != nil {
+		return []domain.User{}, err
+	}
+	return users.ToDomain(), nil
+}
+
+func (repo repositories) UpdateUserWeeklyDigestBySlackID(ctx context.Context, domainUser domain.User) error {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	user := db_entities.NewUserFromDomain(domainUser)
+	result := db.Model(&db_entities.User{}).Where("slack_user_id = ?", user.SlackUserID).Update("weekly_digest", user.WeeklyDigest)
+	if result.Error != nil {
+		repo.logQueryError(ctx, result)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return app_error.UserNotFound
+	}
+
+	return nil
+}
+
+func (repo repositories) UpdateUserDigestScheduleBySlackID(ctx context.Context, domainUser domain.User) error {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	user := db_entities.NewUserFromDomain(domainUser)
+	result := db.Model(&db_entities.User{}).Where("slack_user_id = ?", user.SlackUserID).Updates(map[string]interface{}{
+		"digest_weekday": user.DigestWeekday,
+		"digest_minute":  user.DigestMinute,
+		"timezone":       user.Timezone,
+	})
+	if result.Error != nil {
+		repo.logQueryError(ctx, result)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return app_error.UserNotFound
+	}
+
+	return nil
+}
+
+func (repo repositories) UpdateUserDigestSentAtBySlackID(ctx context.Context, slackID string, sentAt time.Time) error {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	result := db.Model(&db_entities.User{}).Where("slack_user_id = ?", slackID).Update("digest_sent_at", sentAt)
+	if result.Error != nil {
+		repo.logQueryError(ctx, result)
+		return result.Error
+	}
+
+	return nil
+}
+
+func (repo repositories) UpdateUserTeamVisibleBySlackID(ctx context.Context, domainUser domain.User) error {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
 	user := db_entities.NewUserFromDomain(domainUser)
-	result := repo.DB.Model(&db_entities.User{}).Where("slack_user_id = ?", user.SlackUserID).Update("enabled", user.Enabled)
+	result := db.Model(&db_entities.User{}).Where("slack_user_id = ?", user.SlackUserID).Update("team_visible", user.TeamVisible)
 	if result.Error != nil {
-		fmt.Println(result.Statement)
+		repo.logQueryError(ctx, result)
 		return result.Error
 	}
+	if result.RowsAffected == 0 {
+		return app_error.UserNotFound
+	}
 
 	return nil
 }
 
+func (repo repositories) UpdateUserSlackTeamIDBySlackID(ctx context.Context, domainUser domain.User) error {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	user := db_entities.NewUserFromDomain(domainUser)
+	result := db.Model(&db_entities.User{}).Where("slack_user_id = ?", user.SlackUserID).Update("slack_team_id", user.SlackTeamID)
+	if result.Error != nil {
+		repo.logQueryError(ctx, result)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return app_error.UserNotFound
+	}
+
+	return nil
+}
+
+func (repo repositories) UpdateUserNowPlayingBySlackID(ctx context.Context, domainUser domain.User) error {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	user := db_entities.NewUserFromDomain(domainUser)
+	result := db.Model(&db_entities.User{}).Where("slack_user_id = ?", user.SlackUserID).Updates(map[string]interface{}{
+		"now_playing_track_id": user.NowPlayingTrackID,
+		"now_playing_track":    user.NowPlayingTrack,
+		"now_playing_artists":  user.NowPlayingArtists,
+		"now_playing_until":    user.NowPlayingUntil,
+	})
+	if result.Error != nil {
+		repo.logQueryError(ctx, result)
+		return result.Error
+	}
+
+	return nil
+}
+
+func (repo repositories) UpdateUserPollScheduleBySlackID(ctx context.Context, domainUser domain.User) error {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	user := db_entities.NewUserFromDomain(domainUser)
+	result := db.Model(&db_entities.User{}).Where("slack_user_id = ?", user.SlackUserID).Updates(map[string]interface{}{
+		"next_poll_at":   user.NextPollAt,
+		"last_played_at": user.LastPlayedAt,
+	})
+	if result.Error != nil {
+		repo.logQueryError(ctx, result)
+		return result.Error
+	}
+
+	return nil
+}
+
+func (repo repositories) UpdateUserLastPollBySlackID(ctx context.Context, domainUser domain.User) error {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	user := db_entities.NewUserFromDomain(domainUser)
+	result := db.Model(&db_entities.User{}).Where("slack_user_id = ?", user.SlackUserID).Updates(map[string]interface{}{
+		"last_poll_at":      user.LastPollAt,
+		"last_poll_outcome": user.LastPollOutcome,
+		"last_poll_error":   user.LastPollError,
+	})
+	if result.Error != nil {
+		repo.logQueryError(ctx, result)
+		return result.Error
+	}
+
+	return nil
+}
+
+// UpdateUserTokensBySlackID stores the user's tokens, which must already
+// be encrypted
+func (repo repositories) UpdateUserTokensBySlackID(ctx context.Context, domainUser domain.User) error {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	user := db_entities.NewUserFromDomain(domainUser)
+	result := db.Model(&db_entities.User{}).Where("slack_user_id = ?", user.SlackUserID).Updates(map[string]interface{}{
+		"slack_access_token":     user.SlackAccessToken,
+		"slack_bot_access_token": user.SlackBotAccessToken,
+		"spotify_access_token":   user.SpotifyAccessToken,
+		"spotify_refresh_token":  user.SpotifyRefreshToken,
+	})
+	if result.Error != nil {
+		repo.logQueryError(ctx, result)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return app_error.UserNotFound
+	}
+
+	return nil
+}
+
+func (repo repositories) SearchTeamNowPlaying(ctx context.Context, slackTeamID string, at time.Time) ([]domain.User, error) {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	users := db_entities.Users{}
+	err := db.
+		Where("slack_team_id = ? AND enabled = ? AND team_visible = ? AND now_playing_until > ?", slackTeamID, true, true, at).
+		Order("now_playing_track").
+		Find(&users).Error
+	if err != nil {
+		return []domain.User{}, err
+	}
+	return users.ToDomain(), nil
+}
+
+func (repo repositories) SearchUsersBySlackTeamID(ctx context.Context, slackTeamID string) ([]domain.User, error) {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	users := db_entities.Users{}
+	if err := db.Where("slack_team_id = ?", slackTeamID).Find(&users).Error; err != nil {
+		return []domain.User{}, err
+	}
+	return users.ToDomain(), nil
+}
+
 func (repo repositories) RemoveUserBySlackID(ctx context.Context, slackID string) error {
-	result := repo.DB.Where("slack_user_id = ?", slackID).Exec("DELETE FROM users")
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	result := db.Where("slack_user_id = ?", slackID).Delete(&db_entities.User{})
 	if result.Error != nil {
-		fmt.Println(result.Statement)
+		repo.logQueryError(ctx, result)
 		return result.Error
 	}
 	if result.RowsAffected == 0 {
@@ -0,0 +1,79 @@
+package repositories_test
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/o-mago/spotify-status/src/migrations"
+	"github.com/o-mago/spotify-status/src/repositories"
+	"github.com/o-mago/spotify-status/src/repositories/repotest"
+	"gorm.io/gorm"
+)
+
+// Postgres runs only when a disposable database is provided, as the tests
+// drop every table after each case
+const testDatabaseURLEnv = "SPOTIFY_SLACK_APP_TEST_DATABASE_URL"
+
+func TestMemoryRepositories(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repositories.Repositories {
+		return repositories.NewMemoryRepository()
+	})
+}
+
+func TestSQLiteRepositories(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repositories.Repositories {
+		return newDatabaseRepository(t, "sqlite://"+filepath.Join(t.TempDir(), "spotify-status.db"))
+	})
+}
+
+func TestPostgresRepositories(t *testing.T) {
+	databaseURL := os.Getenv(testDatabaseURLEnv)
+	if databaseURL == "" {
+		t.Skip(testDatabaseURLEnv + " is not set")
+	}
+
+	repotest.Run(t, func(t *testing.T) repositories.Repositories {
+		return newDatabaseRepository(t, databaseURL)
+	})
+}
+
+func newDatabaseRepository(t *testing.T, databaseURL string) repositories.Repositories {
+	db, err := repositories.OpenDatabase(databaseURL)
+	if err != nil {
+		t.Fatalf("open database: %s", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if err := migrations.Up(db, logger); err != nil {
+		t.Fatalf("migrate: %s", err)
+	}
+	t.Cleanup(func() { dropAll(t, db) })
+
+	return repositories.NewRepository(db, 5*time.Second, logger)
+}
+
+func dropAll(t *testing.T, db *gorm.DB) {
+	for _, table := range []string{"schema_migrations", "audit_events", "leases", "workspaces", "listening_events", "users"} {
+		if err := db.Migrator().DropTable(table); err != nil {
+			t.Errorf("drop %s: %s", table, err)
+		}
+	}
+}
+
+func TestOpenDatabaseSchemes(t *testing.T) {
+	_, err := repositories.OpenDatabase("mysql://localhost/spotify-status")
+	if err == nil || !strings.Contains(err.Error(), "unsupported database scheme") {
+		t.Errorf("OpenDatabase(mysql://) = %v, want an unsupported scheme error", err)
+	}
+
+	// Nothing listens there, but the DSN is handed to the Postgres driver
+	_, err = repositories.OpenDatabase("host=127.0.0.1 port=1 user=spotify dbname=spotify connect_timeout=1")
+	if err == nil || strings.Contains(err.Error(), "unsupported database scheme") {
+		t.Errorf("OpenDatabase(libpq DSN) = %v, want a connection error", err)
+	}
+}
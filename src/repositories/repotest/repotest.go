@@ -0,0 +1,821 @@
+// Package repotest holds the conformance suite every Repositories
+// implementation must pass.
+package repotest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/o-mago/spotify-status/src/app_error"
+	"github.com/o-mago/spotify-status/src/domain"
+	"github.com/o-mago/spotify-status/src/repositories"
+)
+
+// Run exercises the Repositories contract, calling newRepository for an
+// empty repository in each subtest
+func Run(t *testing.T, newRepository func(t *testing.T) repositories.Repositories) {
+	tests := map[string]func(t *testing.T, repo repositories.Repositories){
+		"CreateUser":              testCreateUser,
+		"EnableToggling":          testEnableToggling,
+		"SearchFiltering":         testSearchFiltering,
+		"UserBatches":             testUserBatches,
+		"UserPages":               testUserPages,
+		"RemoveUser":              testRemoveUser,
+		"UserSettings":            testUserSettings,
+		"Workspaces":              testWorkspaces,
+		"ListeningEvents":         testListeningEvents,
+		"ListeningEventRemoval":   testListeningEventRemoval,
+		"ListeningEventFiltering": testListeningEventFiltering,
+		"AuditEvents":             testAuditEvents,
+		"Leases":                  testLeases,
+		"CanceledContext":         testCanceledContext,
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			test(t, newRepository(t))
+		})
+	}
+}
+
+func createUsers(t *testing.T, repo repositories.Repositories, users ...domain.User) {
+	t.Helper()
+
+	for _, user := range users {
+		if err := repo.CreateUser(context.Background(), user); err != nil {
+			t.Fatalf("CreateUser(%s): %s", user.SlackUserID, err)
+		}
+	}
+}
+
+func testCreateUser(t *testing.T, repo repositories.Repositories) {
+	ctx := context.Background()
+	before := time.Now().Add(-time.Second)
+
+	createUsers(t, repo, domain.User{ID: "user-1", SlackUserID: "U1", SlackTeamID: "T1", SlackAccessToken: "token"})
+
+	user, err := repo.SearchUserBySlackID(ctx, "U1")
+	if err != nil {
+		t.Fatalf("SearchUserBySlackID: %s", err)
+	}
+	if user.ID != "user-1" || user.SlackTeamID != "T1" || user.SlackAccessToken != "token" {
+		t.Errorf("SearchUserBySlackID = %+v, want the created user", user)
+	}
+	if !user.WeeklyDigest || !user.TeamVisible || user.DigestWeekday != time.Monday || user.DigestMinute != 9*60 || user.Timezone != "UTC" {
+		t.Errorf("SearchUserBySlackID = %+v, want the default settings", user)
+	}
+	if user.CreatedAt.Before(before) || user.UpdatedAt.Before(before) {
+		t.Errorf("SearchUserBySlackID = %+v, want the creation timestamps", user)
+	}
+
+	// Creating the same Slack user again keeps the stored row untouched
+	err = repo.CreateUser(ctx, domain.User{ID: "user-2", SlackUserID: "U1", SlackAccessToken: "other"})
+	if !errors.Is(err, app_error.UserAlreadyExists) {
+		t.Errorf("CreateUser with a taken Slack ID = %v, want %v", err, app_error.UserAlreadyExists)
+	}
+
+	user, err = repo.SearchUserBySlackID(ctx, "U1")
+	if err != nil {
+		t.Fatalf("SearchUserBySlackID: %s", err)
+	}
+	if user.ID != "user-1" || user.SlackAccessToken != "token" {
+		t.Errorf("SearchUserBySlackID after duplicate create = %+v, want the original user", user)
+	}
+
+	_, err = repo.SearchUserBySlackID(ctx, "U404")
+	if !errors.Is(err, app_error.UserNotFound) {
+		t.Errorf("SearchUserBySlackID of unknown user = %v, want %v", err, app_error.UserNotFound)
+	}
+}
+
+func testEnableToggling(t *testing.T, repo repositories.Repositories) {
+	ctx := context.Background()
+
+	createUsers(t, repo, domain.User{ID: "user-1", SlackUserID: "U1"})
+
+	for _, enabled := range []bool{true, false, true} {
+		changed, err := repo.UpdateUserEnabledBySlackID(ctx, domain.User{SlackUserID: "U1", Enabled: enabled})
+		if err != nil {
+			t.Fatalf("UpdateUserEnabledBySlackID(%t): %s", enabled, err)
+		}
+		if !changed {
+			t.Errorf("UpdateUserEnabledBySlackID(%t) reported no change", enabled)
+		}
+
+		user, err := repo.SearchUserBySlackID(ctx, "U1")
+		if err != nil {
+			t.Fatalf("SearchUserBySlackID: %s", err)
+		}
+		if user.Enabled != enabled {
+			t.Errorf("Enabled = %t after UpdateUserEnabledBySlackID(%t)", user.Enabled, enabled)
+		}
+	}
+
+	// Enabling an enabled user changes nothing
+	changed, err := repo.UpdateUserEnabledBySlackID(ctx, domain.User{SlackUserID: "U1", Enabled: true})
+	if err != nil || changed {
+		t.Errorf("UpdateUserEnabledBySlackID of enabled user = %t, %v, want no change", changed, err)
+	}
+
+	_, err = repo.UpdateUserEnabledBySlackID(ctx, domain.User{SlackUserID: "U404", Enabled: true})
+	if !errors.Is(err, app_error.UserNotFound) {
+		t.Errorf("UpdateUserEnabledBySlackID of unknown user = %v, want %v", err, app_error.UserNotFound)
+	}
+}
+
+func testSearchFiltering(t *testing.T, repo repositories.Repositories) {
+	ctx := context.Background()
+	now := time.Now()
+
+	createUsers(t, repo,
+		domain.User{ID: "user-1", SlackUserID: "U1", SlackTeamID: "T1", Enabled: true, ListeningHistory: true},
+		domain.User{ID: "user-2", SlackUserID: "U2", SlackTeamID: "T1", Enabled: true},
+		domain.User{ID: "user-3", SlackUserID: "U3", SlackTeamID: "T2"},
+		domain.User{ID: "user-4", SlackUserID: "U4", SlackTeamID: "T2", ListeningHistory: true},
+	)
+
+	assertSlackIDs(t, "SearchUsers", func() ([]domain.User, error) {
+		return repo.SearchUsers(ctx)
+	}, "U1", "U2")
+
+	count, err := repo.CountEnabledUsers(ctx)
+	if err != nil {
+		t.Fatalf("CountEnabledUsers: %s", err)
+	}
+	if count != 2 {
+		t.Errorf("CountEnabledUsers = %d, want 2", count)
+	}
+
+	// U4 keeps their history but disabled the app
+	assertSlackIDs(t, "SearchDigestUsers", func() ([]domain.User, error) {
+		return repo.SearchDigestUsers(ctx)
+	}, "U1")
+
+	assertSlackIDs(t, "SearchUsersBySlackTeamID", func() ([]domain.User, error) {
+		return repo.SearchUsersBySlackTeamID(ctx, "T1")
+	}, "U1", "U2")
+
+	nowPlaying := []domain.User{
+		{SlackUserID: "U1", NowPlayingTrackID: "track-1", NowPlayingTrack: "B", NowPlayingUntil: now.Add(time.Minute)},
+		{SlackUserID: "U2", NowPlayingTrackID: "track-2", NowPlayingTrack: "A", NowPlayingUntil: now.Add(-time.Minute)},
+		{SlackUserID: "U3", NowPlayingTrackID: "track-3", NowPlayingTrack: "C", NowPlayingUntil: now.Add(time.Minute)},
+	}
+	for _, user := range nowPlaying {
+		if err := repo.UpdateUserNowPlayingBySlackID(ctx, user); err != nil {
+			t.Fatalf("UpdateUserNowPlayingBySlackID(%s): %s", user.SlackUserID, err)
+		}
+	}
+
+	// U2's observation expired and U3 is in another workspace
+	assertSlackIDs(t, "SearchTeamNowPlaying", func() ([]domain.User, error) {
+		return repo.SearchTeamNowPlaying(ctx, "T1", now)
+	}, "U1")
+
+	if err := repo.UpdateUserTeamVisibleBySlackID(ctx, domain.User{SlackUserID: "U1", TeamVisible: false}); err != nil {
+		t.Fatalf("UpdateUserTeamVisibleBySlackID: %s", err)
+	}
+
+	assertSlackIDs(t, "SearchTeamNowPlaying of hidden user", func() ([]domain.User, error) {
+		return repo.SearchTeamNowPlaying(ctx, "T1", now)
+	})
+}
+
+func testUserBatches(t *testing.T, repo repositories.Repositories) {
+	ctx := context.Background()
+
+	var enabled, all []string
+	for i := 0; i < 25; i++ {
+		user := domain.User{ID: fmt.Sprintf("user-%02d", i), SlackUserID: fmt.Sprintf("U%02d", i), Enabled: i%5 != 0}
+		createUsers(t, repo, user)
+		if user.Enabled {
+			enabled = append(enabled, user.SlackUserID)
+		}
+		all = append(all, user.SlackUserID)
+	}
+
+	var batchSizes []int
+	assertSlackIDs(t, "SearchUsersInBatches", func() ([]domain.User, error) {
+		var users []domain.User
+		err := repo.SearchUsersInBatches(ctx, domain.UserPollFilter{}, 7, func(batch []domain.User) error {
+			batchSizes = append(batchSizes, len(batch))
+			users = append(users, batch...)
+			return nil
+		})
+		return users, err
+	}, enabled...)
+
+	if want := []int{7, 7, 6}; fmt.Sprint(batchSizes) != fmt.Sprint(want) {
+		t.Errorf("SearchUsersInBatches batch sizes = %v, want %v", batchSizes, want)
+	}
+
+	assertSlackIDs(t, "SearchUsersInBatches including disabled users", func() ([]domain.User, error) {
+		var users []domain.User
+		err := repo.SearchUsersInBatches(ctx, domain.UserPollFilter{IncludeDisabled: true}, 7, func(batch []domain.User) error {
+			users = append(users, batch...)
+			return nil
+		})
+		return users, err
+	}, all...)
+
+	// Every enabled user lands in exactly one shard
+	seen := map[string]int{}
+	for index := 0; index < 3; index++ {
+		shard := domain.UserShard{Index: index, Count: 3}
+		err := repo.SearchUsersInBatches(ctx, domain.UserPollFilter{Shard: shard}, 4, func(batch []domain.User) error {
+			for _, user := range batch {
+				if !shard.Includes(domain.UserPollBucket(user.ID)) {
+					t.Errorf("shard %d returned %s from another shard", index, user.SlackUserID)
+				}
+				seen[user.SlackUserID]++
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("SearchUsersInBatches(shard %d): %s", index, err)
+		}
+	}
+	for _, slackID := range enabled {
+		if seen[slackID] != 1 {
+			t.Errorf("%s was returned by %d shards, want 1", slackID, seen[slackID])
+		}
+	}
+
+	// Only users due for a poll are returned, and users never scheduled
+	// are always due
+	now := time.Now().Truncate(time.Second)
+	schedules := []domain.User{
+		{SlackUserID: "U01", NextPollAt: now.Add(-time.Second), LastPlayedAt: now.Add(-time.Hour)},
+		{SlackUserID: "U02", NextPollAt: now.Add(time.Minute)},
+	}
+	for _, user := range schedules {
+		if err := repo.UpdateUserPollScheduleBySlackID(ctx, user); err != nil {
+			t.Fatalf("UpdateUserPollScheduleBySlackID(%s): %s", user.SlackUserID, err)
+		}
+	}
+
+	var due []string
+	for _, slackID := range enabled {
+		if slackID != "U02" {
+			due = append(due, slackID)
+		}
+	}
+	assertSlackIDs(t, "SearchUsersInBatches of due users", func() ([]domain.User, error) {
+		var users []domain.User
+		err := repo.SearchUsersInBatches(ctx, domain.UserPollFilter{DueAt: now}, 7, func(batch []domain.User) error {
+			users = append(users, batch...)
+			return nil
+		})
+		return users, err
+	}, due...)
+
+	user, err := repo.SearchUserBySlackID(ctx, "U01")
+	if err != nil {
+		t.Fatalf("SearchUserBySlackID: %s", err)
+	}
+	if !user.NextPollAt.Equal(schedules[0].NextPollAt) || !user.LastPlayedAt.Equal(schedules[0].LastPlayedAt) {
+		t.Errorf("SearchUserBySlackID = %+v, want the updated poll schedule", user)
+	}
+
+	// An error from process stops the iteration
+	stop := errors.New("stop")
+	batches := 0
+	err = repo.SearchUsersInBatches(ctx, domain.UserPollFilter{}, 7, func(batch []domain.User) error {
+		batches++
+		return stop
+	})
+	if !errors.Is(err, stop) || batches != 1 {
+		t.Errorf("SearchUsersInBatches with a failing process = %v after %d batches, want %v after 1", err, batches, stop)
+	}
+}
+
+func testUserPages(t *testing.T, repo repositories.Repositories) {
+	ctx := context.Background()
+	polledAt := time.Date(2023, time.March, 6, 10, 0, 0, 0, time.UTC)
+
+	createUsers(t, repo,
+		domain.User{ID: "user-4", SlackUserID: "U4", SlackTeamID: "T2", Enabled: true},
+		domain.User{ID: "user-1", SlackUserID: "U1", SlackTeamID: "T1", Enabled: true},
+		domain.User{ID: "user-3", SlackUserID: "U3", SlackTeamID: "T1", Enabled: true},
+		domain.User{ID: "user-2", SlackUserID: "U2", SlackTeamID: "T1"},
+	)
+
+	err := repo.UpdateUserLastPollBySlackID(ctx, domain.User{SlackUserID: "U3", LastPollAt: polledAt, LastPollOutcome: domain.PollOutcomeError, LastPollError: "spotify: token revoked"})
+	if err != nil {
+		t.Fatalf("UpdateUserLastPollBySlackID: %s", err)
+	}
+	err = repo.UpdateUserLastPollBySlackID(ctx, domain.User{SlackUserID: "U1", LastPollAt: polledAt, LastPollOutcome: "updated"})
+	if err != nil {
+		t.Fatalf("UpdateUserLastPollBySlackID: %s", err)
+	}
+
+	user, err := repo.SearchUserBySlackID(ctx, "U3")
+	if err != nil {
+		t.Fatalf("SearchUserBySlackID: %s", err)
+	}
+	if !user.LastPollAt.Equal(polledAt) || user.LastPollOutcome != domain.PollOutcomeError || user.LastPollError != "spotify: token revoked" {
+		t.Errorf("SearchUserBySlackID = %+v, want the last poll result", user)
+	}
+
+	yes, no := true, false
+	filters := map[string]struct {
+		filter domain.UserListFilter
+		want   []string
+	}{
+		"all":         {domain.UserListFilter{}, []string{"U1", "U2", "U3", "U4"}},
+		"first page":  {domain.UserListFilter{Limit: 2}, []string{"U1", "U2"}},
+		"second page": {domain.UserListFilter{AfterSlackUserID: "U2", Limit: 2}, []string{"U3", "U4"}},
+		"workspace":   {domain.UserListFilter{SlackTeamID: "T1"}, []string{"U1", "U2", "U3"}},
+		"enabled":     {domain.UserListFilter{Enabled: &yes}, []string{"U1", "U3", "U4"}},
+		"disabled":    {domain.UserListFilter{Enabled: &no}, []string{"U2"}},
+		"failing":     {domain.UserListFilter{Failing: &yes}, []string{"U3"}},
+		"not failing": {domain.UserListFilter{SlackTeamID: "T1", Failing: &no}, []string{"U1", "U2"}},
+	}
+
+	for name, test := range filters {
+		users, err := repo.SearchUsersPage(ctx, test.filter)
+		if err != nil {
+			t.Fatalf("SearchUsersPage(%s): %s", name, err)
+		}
+
+		got := make([]string, len(users))
+		for i, user := range users {
+			got[i] = user.SlackUserID
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("SearchUsersPage(%s) = %v, want %v", name, got, test.want)
+		}
+	}
+}
+
+func testRemoveUser(t *testing.T, repo repositories.Repositories) {
+	ctx := context.Background()
+
+	createUsers(t, repo,
+		domain.User{ID: "user-1", SlackUserID: "U1"},
+		domain.User{ID: "user-2", SlackUserID: "U2"},
+	)
+
+	if err := repo.RemoveUserBySlackID(ctx, "U2"); err != nil {
+		t.Fatalf("RemoveUserBySlackID: %s", err)
+	}
+
+	_, err := repo.SearchUserBySlackID(ctx, "U2")
+	if !errors.Is(err, app_error.UserNotFound) {
+		t.Errorf("SearchUserBySlackID of removed user = %v, want %v", err, app_error.UserNotFound)
+	}
+
+	err = repo.RemoveUserBySlackID(ctx, "U2")
+	if !errors.Is(err, app_error.RemoveUserError) {
+		t.Errorf("RemoveUserBySlackID of removed user = %v, want %v", err, app_error.RemoveUserError)
+	}
+
+	// Only the targeted user is removed
+	if _, err := repo.SearchUserBySlackID(ctx, "U1"); err != nil {
+		t.Errorf("SearchUserBySlackID(U1) after removing U2: %s", err)
+	}
+}
+
+func testUserSettings(t *testing.T, repo repositories.Repositories) {
+	ctx := context.Background()
+	sentAt := time.Date(2023, time.March, 6, 9, 0, 0, 0, time.UTC)
+
+	createUsers(t, repo, domain.User{ID: "user-1", SlackUserID: "U1"})
+
+	if err := repo.UpdateUserListeningHistoryBySlackID(ctx, domain.User{SlackUserID: "U1", ListeningHistory: true}); err != nil {
+		t.Fatalf("UpdateUserListeningHistoryBySlackID: %s", err)
+	}
+	if err := repo.UpdateUserWeeklyDigestBySlackID(ctx, domain.User{SlackUserID: "U1", WeeklyDigest: false}); err != nil {
+		t.Fatalf("UpdateUserWeeklyDigestBySlackID: %s", err)
+	}
+	schedule := domain.User{SlackUserID: "U1", DigestWeekday: time.Sunday, DigestMinute: 18 * 60, Timezone: "America/Sao_Paulo"}
+	if err := repo.UpdateUserDigestScheduleBySlackID(ctx, schedule); err != nil {
+		t.Fatalf("UpdateUserDigestScheduleBySlackID: %s", err)
+	}
+	if err := repo.UpdateUserDigestSentAtBySlackID(ctx, "U1", sentAt); err != nil {
+		t.Fatalf("UpdateUserDigestSentAtBySlackID: %s", err)
+	}
+	if err := repo.UpdateUserSlackTeamIDBySlackID(ctx, domain.User{SlackUserID: "U1", SlackTeamID: "T1"}); err != nil {
+		t.Fatalf("UpdateUserSlackTeamIDBySlackID: %s", err)
+	}
+
+	user, err := repo.SearchUserBySlackID(ctx, "U1")
+	if err != nil {
+		t.Fatalf("SearchUserBySlackID: %s", err)
+	}
+	if !user.ListeningHistory || user.WeeklyDigest || user.DigestWeekday != time.Sunday || user.DigestMinute != 18*60 ||
+		user.Timezone != "America/Sao_Paulo" || !user.DigestSentAt.Equal(sentAt) || user.SlackTeamID != "T1" {
+		t.Errorf("SearchUserBySlackID = %+v, want the updated settings", user)
+	}
+
+	tokens := domain.User{SlackUserID: "U1", SlackAccessToken: "slack", SlackBotAccessToken: "bot", SpotifyAccessToken: "spotify", SpotifyRefreshToken: "refresh"}
+	if err := repo.UpdateUserTokensBySlackID(ctx, tokens); err != nil {
+		t.Fatalf("UpdateUserTokensBySlackID: %s", err)
+	}
+	user, err = repo.SearchUserBySlackID(ctx, "U1")
+	if err != nil {
+		t.Fatalf("SearchUserBySlackID: %s", err)
+	}
+	if user.SlackAccessToken != "slack" || user.SlackBotAccessToken != "bot" || user.SpotifyAccessToken != "spotify" ||
+		user.SpotifyRefreshToken != "refresh" || !user.ListeningHistory {
+		t.Errorf("SearchUserBySlackID = %+v, want the updated tokens only", user)
+	}
+
+	settings := map[string]func(user domain.User) error{
+		"UpdateUserListeningHistoryBySlackID": func(user domain.User) error {
+			return repo.UpdateUserListeningHistoryBySlackID(ctx, user)
+		},
+		"UpdateUserWeeklyDigestBySlackID": func(user domain.User) error {
+			return repo.UpdateUserWeeklyDigestBySlackID(ctx, user)
+		},
+		"UpdateUserDigestScheduleBySlackID": func(user domain.User) error {
+			return repo.UpdateUserDigestScheduleBySlackID(ctx, user)
+		},
+		"UpdateUserTeamVisibleBySlackID": func(user domain.User) error {
+			return repo.UpdateUserTeamVisibleBySlackID(ctx, user)
+		},
+		"UpdateUserSlackTeamIDBySlackID": func(user domain.User) error {
+			return repo.UpdateUserSlackTeamIDBySlackID(ctx, user)
+		},
+		"UpdateUserTokensBySlackID": func(user domain.User) error {
+			return repo.UpdateUserTokensBySlackID(ctx, user)
+		},
+	}
+	for name, update := range settings {
+		err := update(domain.User{SlackUserID: "U404", Timezone: "UTC"})
+		if !errors.Is(err, app_error.UserNotFound) {
+			t.Errorf("%s of unknown user = %v, want %v", name, err, app_error.UserNotFound)
+		}
+	}
+}
+
+func testWorkspaces(t *testing.T, repo repositories.Repositories) {
+	ctx := context.Background()
+	sentAt := time.Date(2023, time.March, 6, 0, 0, 0, 0, time.UTC)
+
+	_, err := repo.SearchWorkspace(ctx, "T1")
+	if !errors.Is(err, app_error.WorkspaceNotFound) {
+		t.Errorf("SearchWorkspace of unknown workspace = %v, want %v", err, app_error.WorkspaceNotFound)
+	}
+
+	// Configuring charts first creates the workspace with the team view allowed
+	err = repo.UpdateWorkspaceCharts(ctx, domain.Workspace{SlackTeamID: "T1", ChartsChannel: "C1", ChartsPeriod: domain.ChartsPeriodWeek})
+	if err != nil {
+		t.Fatalf("UpdateWorkspaceCharts: %s", err)
+	}
+	if err := repo.UpdateWorkspaceTeamView(ctx, domain.Workspace{SlackTeamID: "T2", AllowTeamView: false}); err != nil {
+		t.Fatalf("UpdateWorkspaceTeamView: %s", err)
+	}
+	if err := repo.UpdateWorkspaceChartsSentAt(ctx, "T1", sentAt); err != nil {
+		t.Fatalf("UpdateWorkspaceChartsSentAt: %s", err)
+	}
+
+	workspace, err := repo.SearchWorkspace(ctx, "T1")
+	if err != nil {
+		t.Fatalf("SearchWorkspace: %s", err)
+	}
+	if !workspace.AllowTeamView || workspace.ChartsChannel != "C1" || workspace.ChartsPeriod != domain.ChartsPeriodWeek || !workspace.ChartsSentAt.Equal(sentAt) {
+		t.Errorf("SearchWorkspace(T1) = %+v, want charts on C1 every week", workspace)
+	}
+
+	workspace, err = repo.SearchWorkspace(ctx, "T2")
+	if err != nil {
+		t.Fatalf("SearchWorkspace: %s", err)
+	}
+	if workspace.AllowTeamView {
+		t.Errorf("SearchWorkspace(T2) = %+v, want the team view disallowed", workspace)
+	}
+
+	// Updating one setting keeps the other
+	if err := repo.UpdateWorkspaceTeamView(ctx, domain.Workspace{SlackTeamID: "T1", AllowTeamView: false}); err != nil {
+		t.Fatalf("UpdateWorkspaceTeamView: %s", err)
+	}
+
+	workspaces, err := repo.SearchChartsWorkspaces(ctx)
+	if err != nil {
+		t.Fatalf("SearchChartsWorkspaces: %s", err)
+	}
+	if len(workspaces) != 1 || workspaces[0].SlackTeamID != "T1" || workspaces[0].ChartsChannel != "C1" || workspaces[0].AllowTeamView {
+		t.Errorf("SearchChartsWorkspaces = %+v, want only T1", workspaces)
+	}
+
+	// Removing a workspace without settings is not an error
+	for _, slackTeamID := range []string{"T1", "T404"} {
+		if err := repo.RemoveWorkspace(ctx, slackTeamID); err != nil {
+			t.Fatalf("RemoveWorkspace(%s): %s", slackTeamID, err)
+		}
+	}
+	_, err = repo.SearchWorkspace(ctx, "T1")
+	if !errors.Is(err, app_error.WorkspaceNotFound) {
+		t.Errorf("SearchWorkspace of a removed workspace = %v, want %v", err, app_error.WorkspaceNotFound)
+	}
+}
+
+func testListeningEvents(t *testing.T, repo repositories.Repositories) {
+	ctx := context.Background()
+	startedAt := time.Date(2023, time.March, 6, 10, 0, 0, 0, time.UTC)
+
+	event := domain.ListeningEvent{
+		ID:          "event-1",
+		SlackUserID: "U1",
+		SlackTeamID: "T1",
+		TrackID:     "track-1",
+		TrackName:   "Track",
+		Artists:     []string{"Artist", "Featuring"},
+		Album:       "Album",
+		Genres:      []string{"rock"},
+		Duration:    3 * time.Minute,
+		StartedAt:   startedAt,
+	}
+	if err := repo.CreateListeningEvent(ctx, event); err != nil {
+		t.Fatalf("CreateListeningEvent: %s", err)
+	}
+
+	openEvent, err := repo.SearchOpenListeningEvent(ctx, "U1")
+	if err != nil {
+		t.Fatalf("SearchOpenListeningEvent: %s", err)
+	}
+	if openEvent.TrackID != "track-1" || len(openEvent.Artists) != 2 || len(openEvent.Genres) != 1 ||
+		openEvent.Duration != 3*time.Minute || !openEvent.StartedAt.Equal(startedAt) || !openEvent.EndedAt.IsZero() {
+		t.Errorf("SearchOpenListeningEvent = %+v, want the created event", openEvent)
+	}
+
+	endedAt := startedAt.Add(time.Minute)
+	if err := repo.EndListeningEvent(ctx, "event-1", endedAt); err != nil {
+		t.Fatalf("EndListeningEvent: %s", err)
+	}
+
+	_, err = repo.SearchOpenListeningEvent(ctx, "U1")
+	if !errors.Is(err, app_error.ListeningEventNotFound) {
+		t.Errorf("SearchOpenListeningEvent after ending = %v, want %v", err, app_error.ListeningEventNotFound)
+	}
+
+	err = repo.EndListeningEvent(ctx, "event-404", endedAt)
+	if !errors.Is(err, app_error.ListeningEventNotFound) {
+		t.Errorf("EndListeningEvent of unknown event = %v, want %v", err, app_error.ListeningEventNotFound)
+	}
+
+	events, err := repo.SearchListeningEvents(ctx, domain.ListeningEventFilter{SlackUserID: "U1"})
+	if err != nil {
+		t.Fatalf("SearchListeningEvents: %s", err)
+	}
+	if len(events) != 1 || !events[0].EndedAt.Equal(endedAt) {
+		t.Errorf("SearchListeningEvents = %+v, want one event ended at %s", events, endedAt)
+	}
+}
+
+func testListeningEventRemoval(t *testing.T, repo repositories.Repositories) {
+	ctx := context.Background()
+	startedAt := time.Date(2023, time.March, 6, 10, 0, 0, 0, time.UTC)
+
+	createListeningEvents(t, repo,
+		domain.ListeningEvent{ID: "event-1", SlackUserID: "U1", StartedAt: startedAt},
+		domain.ListeningEvent{ID: "event-2", SlackUserID: "U1", StartedAt: startedAt.Add(time.Hour)},
+		domain.ListeningEvent{ID: "event-3", SlackUserID: "U2", StartedAt: startedAt},
+	)
+
+	removed, err := repo.RemoveListeningEventsBefore(ctx, startedAt.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("RemoveListeningEventsBefore: %s", err)
+	}
+	if removed != 2 {
+		t.Errorf("RemoveListeningEventsBefore removed %d events, want 2", removed)
+	}
+
+	if err := repo.RemoveListeningEventsBySlackID(ctx, "U1"); err != nil {
+		t.Fatalf("RemoveListeningEventsBySlackID: %s", err)
+	}
+
+	events, err := repo.SearchListeningEvents(ctx, domain.ListeningEventFilter{})
+	if err != nil {
+		t.Fatalf("SearchListeningEvents: %s", err)
+	}
+	if len(events) != 0 {
+		t.Errorf("SearchListeningEvents = %+v, want no events left", events)
+	}
+}
+
+func testListeningEventFiltering(t *testing.T, repo repositories.Repositories) {
+	ctx := context.Background()
+	startedAt := time.Date(2023, time.March, 6, 10, 0, 0, 0, time.UTC)
+
+	createListeningEvents(t, repo,
+		domain.ListeningEvent{ID: "event-2", SlackUserID: "U1", SlackTeamID: "T1", StartedAt: startedAt.Add(time.Hour)},
+		domain.ListeningEvent{ID: "event-1", SlackUserID: "U1", SlackTeamID: "T1", StartedAt: startedAt},
+		domain.ListeningEvent{ID: "event-3", SlackUserID: "U2", SlackTeamID: "T1", StartedAt: startedAt.Add(2 * time.Hour)},
+		domain.ListeningEvent{ID: "event-4", SlackUserID: "U3", SlackTeamID: "T2", StartedAt: startedAt},
+	)
+
+	filters := map[string]struct {
+		filter domain.ListeningEventFilter
+		want   []string
+	}{
+		"user":  {domain.ListeningEventFilter{SlackUserID: "U1"}, []string{"event-1", "event-2"}},
+		"team":  {domain.ListeningEventFilter{SlackTeamID: "T1"}, []string{"event-1", "event-2", "event-3"}},
+		"from":  {domain.ListeningEventFilter{SlackTeamID: "T1", From: startedAt.Add(time.Hour)}, []string{"event-2", "event-3"}},
+		"to":    {domain.ListeningEventFilter{SlackTeamID: "T1", To: startedAt.Add(time.Hour)}, []string{"event-1"}},
+		"range": {domain.ListeningEventFilter{From: startedAt, To: startedAt.Add(time.Minute)}, []string{"event-1", "event-4"}},
+		"limit": {domain.ListeningEventFilter{SlackTeamID: "T1", Limit: 2}, []string{"event-2", "event-3"}},
+	}
+
+	for name, test := range filters {
+		events, err := repo.SearchListeningEvents(ctx, test.filter)
+		if err != nil {
+			t.Fatalf("SearchListeningEvents(%s): %s", name, err)
+		}
+
+		got := map[string]bool{}
+		for i, event := range events {
+			got[event.ID] = true
+			if i > 0 && event.StartedAt.Before(events[i-1].StartedAt) {
+				t.Errorf("SearchListeningEvents(%s) is not sorted by start time", name)
+			}
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("SearchListeningEvents(%s) = %d events, want %v", name, len(events), test.want)
+		}
+		for _, id := range test.want {
+			if !got[id] {
+				t.Errorf("SearchListeningEvents(%s) is missing %s", name, id)
+			}
+		}
+	}
+}
+
+func testAuditEvents(t *testing.T, repo repositories.Repositories) {
+	ctx := context.Background()
+	createdAt := time.Date(2023, time.March, 6, 10, 0, 0, 0, time.UTC)
+
+	for _, event := range []domain.AuditEvent{
+		{ID: "audit-1", SlackUserID: "U1", SlackTeamID: "T1", Action: domain.AuditUserAdded, Actor: "U1", CreatedAt: createdAt},
+		{ID: "audit-2", SlackUserID: "U1", SlackTeamID: "T1", Action: domain.AuditStatusSet, Actor: domain.AuditActorApp, Detail: "Song - Artist", CreatedAt: createdAt.Add(time.Hour)},
+		{ID: "audit-3", SlackUserID: "U1", SlackTeamID: "T1", Action: domain.AuditStatusCleared, Actor: domain.AuditActorApp, CreatedAt: createdAt.Add(2 * time.Hour)},
+		{ID: "audit-4", SlackUserID: "U2", SlackTeamID: "T2", Action: domain.AuditUserAdded, Actor: "U2", CreatedAt: createdAt.Add(time.Hour)},
+	} {
+		if err := repo.CreateAuditEvent(ctx, event); err != nil {
+			t.Fatalf("CreateAuditEvent(%s): %s", event.ID, err)
+		}
+	}
+
+	filters := map[string]struct {
+		filter domain.AuditEventFilter
+		want   []string
+	}{
+		"all":    {domain.AuditEventFilter{}, []string{"audit-3", "audit-4", "audit-2", "audit-1"}},
+		"user":   {domain.AuditEventFilter{SlackUserID: "U1"}, []string{"audit-3", "audit-2", "audit-1"}},
+		"team":   {domain.AuditEventFilter{SlackTeamID: "T2"}, []string{"audit-4"}},
+		"action": {domain.AuditEventFilter{Action: domain.AuditUserAdded}, []string{"audit-4", "audit-1"}},
+		"range":  {domain.AuditEventFilter{From: createdAt.Add(time.Hour), To: createdAt.Add(2 * time.Hour)}, []string{"audit-4", "audit-2"}},
+		"limit":  {domain.AuditEventFilter{SlackUserID: "U1", Limit: 2}, []string{"audit-3", "audit-2"}},
+	}
+
+	for name, test := range filters {
+		events, err := repo.SearchAuditEvents(ctx, test.filter)
+		if err != nil {
+			t.Fatalf("SearchAuditEvents(%s): %s", name, err)
+		}
+
+		got := make([]string, len(events))
+		for i, event := range events {
+			got[i] = event.ID
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("SearchAuditEvents(%s) = %v, want %v", name, got, test.want)
+		}
+	}
+
+	events, err := repo.SearchAuditEvents(ctx, domain.AuditEventFilter{Action: domain.AuditStatusSet})
+	if err != nil {
+		t.Fatalf("SearchAuditEvents: %s", err)
+	}
+	want := domain.AuditEvent{ID: "audit-2", SlackUserID: "U1", SlackTeamID: "T1", Action: domain.AuditStatusSet, Actor: domain.AuditActorApp, Detail: "Song - Artist"}
+	if len(events) != 1 || !events[0].CreatedAt.Equal(createdAt.Add(time.Hour)) {
+		t.Fatalf("SearchAuditEvents = %+v, want audit-2", events)
+	}
+	events[0].CreatedAt = time.Time{}
+	if events[0] != want {
+		t.Errorf("SearchAuditEvents = %+v, want %+v", events[0], want)
+	}
+
+	removed, err := repo.RemoveAuditEventsBefore(ctx, createdAt.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("RemoveAuditEventsBefore: %s", err)
+	}
+	if removed != 1 {
+		t.Errorf("RemoveAuditEventsBefore removed %d events, want 1", removed)
+	}
+}
+
+func testLeases(t *testing.T, repo repositories.Repositories) {
+	ctx := context.Background()
+	now := time.Date(2023, time.March, 6, 10, 0, 0, 0, time.UTC)
+
+	acquire := func(holder string, at time.Time) error {
+		return repo.AcquireLease(ctx, domain.Lease{Name: "scheduler", Holder: holder, ExpiresAt: at.Add(30 * time.Second)}, at)
+	}
+
+	if err := acquire("a", now); err != nil {
+		t.Fatalf("AcquireLease of a free lease: %s", err)
+	}
+
+	// The holder renews its lease while others wait for it to expire
+	if err := acquire("b", now.Add(10*time.Second)); !errors.Is(err, app_error.LeaseHeld) {
+		t.Errorf("AcquireLease of a held lease = %v, want %v", err, app_error.LeaseHeld)
+	}
+	if err := acquire("a", now.Add(20*time.Second)); err != nil {
+		t.Errorf("AcquireLease renewal: %s", err)
+	}
+	if err := acquire("b", now.Add(40*time.Second)); !errors.Is(err, app_error.LeaseHeld) {
+		t.Errorf("AcquireLease of a renewed lease = %v, want %v", err, app_error.LeaseHeld)
+	}
+
+	if err := acquire("b", now.Add(time.Minute)); err != nil {
+		t.Errorf("AcquireLease of an expired lease: %s", err)
+	}
+
+	// Only the holder can release a lease
+	if err := repo.ReleaseLease(ctx, "scheduler", "a"); err != nil {
+		t.Fatalf("ReleaseLease: %s", err)
+	}
+	if err := acquire("a", now.Add(time.Minute)); !errors.Is(err, app_error.LeaseHeld) {
+		t.Errorf("AcquireLease after another holder's release = %v, want %v", err, app_error.LeaseHeld)
+	}
+
+	if err := repo.ReleaseLease(ctx, "scheduler", "b"); err != nil {
+		t.Fatalf("ReleaseLease: %s", err)
+	}
+	if err := acquire("a", now.Add(time.Minute)); err != nil {
+		t.Errorf("AcquireLease of a released lease: %s", err)
+	}
+}
+
+func testCanceledContext(t *testing.T, repo repositories.Repositories) {
+	if err := repo.Ping(context.Background()); err != nil {
+		t.Errorf("Ping: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := repo.Ping(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Ping with a canceled context = %v, want %v", err, context.Canceled)
+	}
+
+	err := repo.CreateUser(ctx, domain.User{ID: "user-1", SlackUserID: "U1"})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("CreateUser with a canceled context = %v, want %v", err, context.Canceled)
+	}
+
+	_, err = repo.SearchUsers(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("SearchUsers with a canceled context = %v, want %v", err, context.Canceled)
+	}
+
+	err = repo.SearchUsersInBatches(ctx, domain.UserPollFilter{}, 10, func(users []domain.User) error {
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("SearchUsersInBatches with a canceled context = %v, want %v", err, context.Canceled)
+	}
+
+	_, err = repo.SearchUserBySlackID(context.Background(), "U1")
+	if !errors.Is(err, app_error.UserNotFound) {
+		t.Errorf("SearchUserBySlackID after a canceled create = %v, want %v", err, app_error.UserNotFound)
+	}
+}
+
+func createListeningEvents(t *testing.T, repo repositories.Repositories, events ...domain.ListeningEvent) {
+	t.Helper()
+
+	for _, event := range events {
+		if err := repo.CreateListeningEvent(context.Background(), event); err != nil {
+			t.Fatalf("CreateListeningEvent(%s): %s", event.ID, err)
+		}
+	}
+}
+
+func assertSlackIDs(t *testing.T, name string, search func() ([]domain.User, error), want ...string) {
+	t.Helper()
+
+	users, err := search()
+	if err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+
+	got := map[string]bool{}
+	for _, user := range users {
+		got[user.SlackUserID] = true
+	}
+
+	if len(users) != len(want) {
+		t.Errorf("%s returned %d users, want %v", name, len(users), want)
+	}
+	for _, slackID := range want {
+		if !got[slackID] {
+			t.Errorf("%s is missing %s", name, slackID)
+		}
+	}
+}
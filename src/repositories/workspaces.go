@@ -0,0 +1,106 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/o-mago/spotify-status/src/app_error"
+	"github.com/o-mago/spotify-status/src/domain"
+	"github.com/o-mago/spotify-status/src/repositories/db_entities"
+	"gorm.io/gorm/clause"
+)
+
+func (repo repositories) SearchWorkspace(ctx context.Context, slackTeamID string) (domain.Workspace, error) {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	workspace := db_entities.Workspace{}
+	result := db.Where("slack_team_id = ?", slackTeamID).Limit(1).Find(&workspace)
+	if result.Error != nil {
+		repo.logQueryError(ctx, result)
+		return domain.Workspace{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return domain.Workspace{}, app_error.WorkspaceNotFound
+	}
+	return workspace.ToDomain(), nil
+}
+
+func (repo repositories) UpdateWorkspaceTeamView(ctx context.Context, domainWorkspace domain.Workspace) error {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	workspace := db_entities.NewWorkspaceFromDomain(domainWorkspace)
+
+	// Created from a map so a false value isn't replaced by the column default
+	now := time.Now()
+	result := db.Model(&db_entities.Workspace{}).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "slack_team_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"allow_team_view": workspace.AllowTeamView, "updated_at": now}),
+	}).Create(map[string]interface{}{
+		"slack_team_id":   workspace.SlackTeamID,
+		"allow_team_view": workspace.AllowTeamView,
+		"created_at":      now,
+		"updated_at":      now,
+	})
+	if result.Error != nil {
+		repo.logQueryError(ctx, result)
+		return result.Error
+	}
+	return nil
+}
+
+func (repo repositories) SearchChartsWorkspaces(ctx context.Context) ([]domain.Workspace, error) {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	workspaces := db_entities.Workspaces{}
+	if err := db.Where("charts_channel <> ?", "").Find(&workspaces).Error; err != nil {
+		return []domain.Workspace{}, err
+	}
+	return workspaces.ToDomain(), nil
+}
+
+func (repo repositories) UpdateWorkspaceCharts(ctx context.Context, domainWorkspace domain.Workspace) error {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	workspace := db_entities.NewWorkspaceFromDomain(domainWorkspace)
+	result := db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "slack_team_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"charts_channel": workspace.ChartsChannel,
+			"charts_period":  workspace.ChartsPeriod,
+		}),
+	}).Create(&workspace)
+	if result.Error != nil {
+		repo.logQueryError(ctx, result)
+		return result.Error
+	}
+	return nil
+}
+
+func (repo repositories) UpdateWorkspaceChartsSentAt(ctx context.Context, slackTeamID string, sentAt time.Time) error {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	result := db.Model(&db_entities.Workspace{}).Where("slack_team_id = ?", slackTeamID).Update("charts_sent_at", sentAt)
+	if result.Error != nil {
+		repo.logQueryError(ctx, result)
+		return result.Error
+	}
+	return nil
+}
+
+// RemoveWorkspace removes the workspace's settings, if it has any
+func (repo repositories) RemoveWorkspace(ctx context.Context, slackTeamID string) error {
+	db, cancel := repo.withTimeout(ctx)
+	defer cancel()
+
+	result := db.Where("slack_team_id = ?", slackTeamID).Delete(&db_entities.Workspace{})
+	if result.Error != nil {
+		repo.logQueryError(ctx, result)
+		return result.Error
+	}
+	return nil
+}
@@ -5,120 +5,413 @@ import (
 	"crypto/rand"
 	"flag"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
-	"os/signal"
+	"sync/atomic"
 	"syscall"
 	"time"
+	_ "time/tzdata"
 
-	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/google/uuid"
+	"github.com/o-mago/spotify-status/src/config"
 	"github.com/o-mago/spotify-status/src/crypto"
+	"github.com/o-mago/spotify-status/src/domain"
 	"github.com/o-mago/spotify-status/src/handlers"
+	"github.com/o-mago/spotify-status/src/health"
+	"github.com/o-mago/spotify-status/src/leader"
+	"github.com/o-mago/spotify-status/src/lifecycle"
+	"github.com/o-mago/spotify-status/src/logging"
+	"github.com/o-mago/spotify-status/src/metrics"
+	"github.com/o-mago/spotify-status/src/migrations"
 	"github.com/o-mago/spotify-status/src/repositories"
-	"github.com/o-mago/spotify-status/src/repositories/db_entities"
 	"github.com/o-mago/spotify-status/src/services"
+	"github.com/o-mago/spotify-status/src/telemetry"
 	"github.com/robfig/cron/v3"
 	"github.com/zmb3/spotify"
-	"gorm.io/driver/postgres"
+	"golang.org/x/oauth2"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 )
 
+// Fly.io gives up on a check after 2s
+const healthCheckTimeout = time.Second
+
+const adminAPIPath = "/admin/api/"
+
+const usage = `Usage: spotify-status [flags] [command]
+
+Commands:
+  serve                      run the server (default)
+  migrate [up | down [steps] | status]
+                             apply or revert the database migrations
+  users list [flags]         list the users, -h for the filters
+  users disable <slack-id>   stop updating a user's status
+  poll-once --user <slack-id>
+                             poll a user right away and print the result
+  rotate-keys                re-encrypt the stored tokens with the active key
+  config check               validate the configuration and print it
+  transit-dev [address]      serve a local Vault transit stand-in
+
+Flags:
+`
+
 func main() {
 	var wait time.Duration
-	flag.DurationVar(&wait, "graceful-timeout", time.Second*15, "the duration for which the server gracefully wait for existing connections to finish - e.g. 15s or 1m")
+	var configFile string
+	flag.DurationVar(&wait, "graceful-timeout", time.Second*15, "the duration for which the server gracefully wait for existing connections and jobs to finish - e.g. 15s or 1m")
+	flag.StringVar(&configFile, "config", os.Getenv("SPOTIFY_SLACK_APP_CONFIG_FILE"), "optional YAML config file, overridden by the environment variables")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	// Get environment variables
-	newRelicAppName := os.Getenv("SPOTIFY_SLACK_APP_NEW_RELIC_APP_NAME")
-	newRelicLicense := os.Getenv("SPOTIFY_SLACK_APP_NEW_RELIC_LICENSE")
-	databaseURL := os.Getenv("SPOTIFY_SLACK_APP_DATABASE_URL")
-	slackAuthURL := os.Getenv("SPOTIFY_SLACK_APP_SLACK_AUTH_URL")
-	spotifyRedirectURL := os.Getenv("SPOTIFY_SLACK_APP_SPOTIFY_REDIRECT_URL")
-	slackClientID := os.Getenv("SPOTIFY_SLACK_APP_SLACK_CLIENT_ID")
-	slackClientSecret := os.Getenv("SPOTIFY_SLACK_APP_SLACK_CLIENT_SECRET")
-	spotifyClientID := os.Getenv("SPOTIFY_SLACK_APP_SPOTIFY_CLIENT_ID")
-	spotifyClientSecret := os.Getenv("SPOTIFY_SLACK_APP_SPOTIFY_CLIENT_SECRET")
-	cryptoKey := os.Getenv("SPOTIFY_SLACK_APP_CRYPTO_KEY")
-	slackSigningSecret := os.Getenv("SPOTIFY_SLACK_APP_SIGNING_SECRET")
-	port := os.Getenv("PORT")
-
-	// Setup New Relic
-	newRelicApp, err := newrelic.NewApplication(
-		newrelic.ConfigAppName(newRelicAppName),
-		newrelic.ConfigLicense(newRelicLicense),
-		newrelic.ConfigDistributedTracerEnabled(true),
-	)
+	// Load and check the configuration
+	cfg, err := config.Load(configFile)
 	if err != nil {
 		fmt.Printf("Error: %s\n", err)
-		return
+		os.Exit(1)
 	}
 
-	// Setup connection to the database
-	db, err := gorm.Open(postgres.Open(databaseURL), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Silent),
+	// The flag takes precedence over the configuration when set
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "graceful-timeout" {
+			cfg.GracefulTimeout = wait
+		}
 	})
+
+	command := flag.Arg(0)
+	if command == "" {
+		command = "serve"
+	}
+	var args []string
+	if flag.NArg() > 1 {
+		args = flag.Args()[1:]
+	}
+
+	switch command {
+	case "serve", "migrate", "users", "poll-once", "rotate-keys", "config", "transit-dev":
+	default:
+		fmt.Fprintf(flag.CommandLine.Output(), "Error: unknown command %q\n\n", command)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if command == "config" {
+		os.Exit(checkConfig(cfg, args))
+	}
+
+	// Migrations only need the database
+	if command != "migrate" {
+		err = cfg.Validate()
+		if err != nil {
+			fmt.Printf("Error: invalid configuration:\n%s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Everything logs through this logger, which never writes tokens. The
+	// operator commands log to stderr, keeping stdout for their output.
+	logOutput := os.Stdout
+	if command != "serve" {
+		logOutput = os.Stderr
+	}
+	logger, err := logging.New(logOutput, cfg.LogLevel, cfg.LogFormat)
 	if err != nil {
-		panic("failed to connect database")
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
 	}
+	slog.SetDefault(logger)
 
-	db.AutoMigrate(&db_entities.User{})
+	switch command {
+	case "serve":
+		fmt.Print(cfg.Redacted())
+		err = serve(cfg, logger)
+	case "migrate":
+		err = migrate(cfg, args, logger)
+	case "transit-dev":
+		err = serveLocalTransit(cfg, args, logger)
+	default:
+		err = runCommand(cfg, logger, command, args)
+	}
+	if err != nil {
+		logger.Error(command+" failed", "error", err)
+		os.Exit(1)
+	}
+}
 
-	// Creating Spotify Authenticator
-	spotifyAuthenticator := spotify.NewAuthenticator(spotifyRedirectURL, spotify.ScopeUserReadCurrentlyPlaying)
-	spotifyAuthenticator.SetAuthInfo(spotifyClientID, spotifyClientSecret)
+// layers are the database and the app layers every command working on
+// users shares with the server
+type layers struct {
+	db           *gorm.DB
+	crypto       crypto.Crypto
+	repositories repositories.Repositories
+	services     services.Services
+}
+
+// newLayers connects to the database and creates the layers on top of it,
+// leaving the schema as it is
+func newLayers(cfg config.Config, logger *slog.Logger) (layers, error) {
+	db, err := repositories.OpenDatabase(cfg.DatabaseURL)
+	if err != nil {
+		return layers{}, fmt.Errorf("connecting to the database: %w", err)
+	}
+
+	// Same settings as the server's authenticator, used by the services to
+	// create context-bound Spotify clients
+	spotifyOAuthConfig := &oauth2.Config{
+		ClientID:     cfg.SpotifyClientID,
+		ClientSecret: cfg.SpotifyClientSecret,
+		RedirectURL:  cfg.SpotifyRedirectURL,
+		Scopes:       []string{spotify.ScopeUserReadCurrentlyPlaying},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  spotify.AuthURL,
+			TokenURL: spotify.TokenURL,
+		},
+	}
 
-	// Creating crypto instance
-	crypto := crypto.NewCrypto([]byte(cryptoKey))
+	// Creating the keyring, checked along with the configuration
+	crypto, err := cfg.Crypto()
+	if err != nil {
+		closeDatabase(db)
+		return layers{}, fmt.Errorf("creating the keyring: %w", err)
+	}
+
+	repositories := repositories.NewRepository(db, cfg.DatabaseQueryTimeout, logger)
+	services := services.NewServices(repositories, spotifyOAuthConfig, crypto, cfg.ListeningHistoryRetention, cfg.AuditRetention, cfg.ChartsMinListeners, logger)
+
+	return layers{
+		db:           db,
+		crypto:       crypto,
+		repositories: repositories,
+		services:     services,
+	}, nil
+}
+
+func closeDatabase(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+
+	return sqlDB.Close()
+}
+
+// serve runs the server until SIGINT or SIGTERM
+func serve(cfg config.Config, logger *slog.Logger) error {
+	// Components register how to stop as they start, and are stopped in
+	// reverse order
+	lc := lifecycle.NewManager(logger)
 
 	// Creating app layers (repositories, services, handlers)
-	repositories := repositories.NewRepository(db)
-	services := services.NewServices(repositories, spotifyAuthenticator, crypto)
-	handlers := handlers.NewHandlers(services, spotifyAuthenticator, stateGenerator(), slackClientID, slackClientSecret, slackAuthURL, slackSigningSecret)
+	layers, err := newLayers(cfg, logger)
+	if err != nil {
+		return err
+	}
+
+	// Only the server and migrate change the schema
+	err = migrations.Up(layers.db, logger)
+	if err != nil {
+		closeDatabase(layers.db)
+		return fmt.Errorf("migrating: %w", err)
+	}
+	lc.OnShutdown("database", func(ctx context.Context) error {
+		return closeDatabase(layers.db)
+	})
+	crypto, repositories, services := layers.crypto, layers.repositories, layers.services
+
+	// Setup telemetry
+	tel := newTelemetry(cfg, logger)
+	lc.OnShutdown("telemetry", tel.Shutdown)
+
+	// Creating Spotify Authenticator
+	spotifyAuthenticator := spotify.NewAuthenticator(cfg.SpotifyRedirectURL, spotify.ScopeUserReadCurrentlyPlaying)
+	spotifyAuthenticator.SetAuthInfo(cfg.SpotifyClientID, cfg.SpotifyClientSecret)
+
+	adminUsername, adminPassword := cfg.AdminAPIBasicAuthCredentials()
+	adminAPI := handlers.NewAdminAPI(services, handlers.AdminCredentials{
+		Token:    cfg.AdminAPIToken,
+		Username: adminUsername,
+		Password: adminPassword,
+	}, logger)
+	handlers := handlers.NewHandlers(services, spotifyAuthenticator, stateGenerator(), cfg.SlackClientID, cfg.SlackClientSecret, cfg.SlackAuthURL, cfg.SlackSigningSecret, logger)
+
+	// Only the replica holding the scheduler lease runs the cron jobs, so
+	// scaling out doesn't double-write statuses or send digests twice
+	hostname, _ := os.Hostname()
+	elector := leader.NewElector(repositories, "scheduler", hostname+"-"+uuid.New().String(), cfg.LeaderLeaseTTL, logger)
+	electionCtx, stopElection := context.WithCancel(context.Background())
+	electionDone := make(chan struct{})
+	go func() {
+		elector.Run(electionCtx)
+		close(electionDone)
+	}()
+	lc.OnShutdown("leader election", func(ctx context.Context) error {
+		stopElection()
+
+		select {
+		case <-electionDone:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	// Statuses are only cleared by the leader, which set them, and once its
+	// jobs are done
+	if cfg.ClearStatusesOnShutdown {
+		lc.OnShutdown("statuses", func(ctx context.Context) error {
+			if !elector.IsLeader() {
+				return nil
+			}
+
+			return services.ClearUserStatuses(ctx)
+		})
+	}
+
+	// Jobs still running when the graceful timeout ends are canceled
+	// through jobsCtx. Each run is a root span, so the work it does shows
+	// up under it.
+	jobsCtx, cancelJobs := context.WithCancel(context.Background())
+	leaderOnly := func(name string, job func(ctx context.Context) error) func() {
+		return func() {
+			if !elector.IsLeader() {
+				return
+			}
+
+			ctx, span := tel.StartSpan(jobsCtx, name)
+			defer span.End()
+
+			if err := job(ctx); err != nil {
+				span.RecordError(err)
+				logger.ErrorContext(ctx, "job failed", "job", name, "error", err)
+			}
+		}
+	}
 
 	// Setup cronjob for updating status
 	c := cron.New(cron.WithSeconds())
-	c.AddFunc("@every 10s", func() { services.ChangeUserStatus(context.Background()) })
+	// Bounding each tick by the interval keeps slow ticks from piling up
+	pollInterval := cfg.PollInterval
+	var pollTick atomic.Uint64
+	// Followers have nothing to poll, so their ticks always count as
+	// successful for the readiness check
+	pollHeartbeat := health.NewHeartbeat()
+	changeUserStatus := leaderOnly("ChangeUserStatus", func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, pollInterval)
+		defer cancel()
+
+		tick := pollTick.Add(1) - 1
+		err := services.ChangeUserStatus(ctx, domain.UserShard{
+			Index: int(tick % uint64(cfg.PollShards)),
+			Count: cfg.PollShards,
+		})
+		if err == nil {
+			pollHeartbeat.Beat()
+		}
+		return err
+	})
+	c.AddFunc("@every "+pollInterval.String(), func() {
+		if !elector.IsLeader() {
+			pollHeartbeat.Beat()
+		}
+		changeUserStatus()
+	})
+	c.AddFunc("@daily", leaderOnly("PruneListeningEvents", services.PruneListeningEvents))
+	c.AddFunc("@daily", leaderOnly("PruneAuditEvents", services.PruneAuditEvents))
+	c.AddFunc("0 */15 * * * *", leaderOnly("SendWeeklyDigests", services.SendWeeklyDigests))
+	c.AddFunc("@hourly", leaderOnly("PostWorkspaceCharts", services.PostWorkspaceCharts))
 	c.Start()
+	var scheduler health.Running
+	scheduler.Set(true)
+	lc.OnShutdown("scheduler", func(ctx context.Context) error {
+		defer cancelJobs()
+		scheduler.Set(false)
 
-	// Add handlers
+		select {
+		case <-c.Stop().Done():
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	// Add handlers, each request recorded by the telemetry and logged with
+	// its request ID
 	mux := http.NewServeMux()
-	mux.HandleFunc("/callback", handlers.SpotifyCallbackHandler)
-	mux.HandleFunc("/slackAuth", handlers.SlackCallbackHandler)
-	mux.HandleFunc("/opt-in", handlers.OptInHandler)
-	mux.HandleFunc("/opt-out", handlers.OptOutHandler)
-	mux.HandleFunc("/disable", handlers.DisableHandler)
-	mux.HandleFunc("/enable", handlers.EnableHandler)
-	mux.HandleFunc(newrelic.WrapHandleFunc(newRelicApp, "/users", handlers.HealthHandler))
+	handle := func(pattern string, handler http.Handler) {
+		mux.Handle(pattern, tel.Handler(pattern, handler))
+	}
+	handle("/callback", http.HandlerFunc(handlers.SpotifyCallbackHandler))
+	handle("/slackAuth", http.HandlerFunc(handlers.SlackCallbackHandler))
+	handle("/opt-in", http.HandlerFunc(handlers.OptInHandler))
+	handle("/opt-out", http.HandlerFunc(handlers.OptOutHandler))
+	handle("/disable", http.HandlerFunc(handlers.DisableHandler))
+	handle("/enable", http.HandlerFunc(handlers.EnableHandler))
+	handle("/spotify-status", http.HandlerFunc(handlers.CommandHandler))
+	handle("/interactivity", http.HandlerFunc(handlers.InteractivityHandler))
+	handle("/metrics", metrics.Handler(repositories.CountEnabledUsers))
+	if cfg.AdminAPIEnabled() {
+		handle(adminAPIPath, http.StripPrefix(adminAPIPath, adminAPI))
+	}
+
+	// Liveness only tells the process serves requests, readiness that it
+	// can do its job. /users is the former health check.
+	liveness := health.Handler(healthCheckTimeout, nil)
+	handle("/healthz", liveness)
+	handle("/users", liveness)
+	handle("/readyz", health.Handler(healthCheckTimeout, map[string]health.Check{
+		"database": repositories.Ping,
+		"crypto": func(ctx context.Context) error {
+			return crypto.Check()
+		},
+		"poll":      pollHeartbeat.Check(cfg.ReadyPollMaxAge),
+		"scheduler": scheduler.Check,
+	}))
 	fsHome := http.FileServer(http.Dir("./static/home"))
-	mux.Handle("/", fsHome)
+	handle("/", fsHome)
 
 	srv := &http.Server{
-		Addr:         ":" + port,
+		Addr:         ":" + cfg.Port,
 		WriteTimeout: time.Second * 15,
 		ReadTimeout:  time.Second * 15,
 		IdleTimeout:  time.Second * 60,
-		Handler:      mux,
+		Handler:      logging.Middleware(logger, mux),
 	}
 
 	go func() {
-		if err := srv.ListenAndServe(); err != nil {
-			fmt.Println(err)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("serving failed", "error", err)
 		}
 	}()
+	lc.OnShutdown("http server", srv.Shutdown)
 
-	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+	lc.Wait(cfg.GracefulTimeout, os.Interrupt, syscall.SIGTERM)
 
-	<-ch
+	logger.Info("shut down")
+	return nil
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), wait)
-	defer cancel()
+// newTelemetry falls back to no telemetry when the configured one fails to
+// start, so monitoring can't keep the app down
+func newTelemetry(cfg config.Config, logger *slog.Logger) telemetry.Telemetry {
+	var tel telemetry.Telemetry
+	var err error
 
-	srv.Shutdown(ctx)
+	switch cfg.TelemetryProvider() {
+	case telemetry.NewRelic:
+		tel, err = telemetry.NewNewRelic(cfg.NewRelicAppName, cfg.NewRelicLicense)
+	case telemetry.OTLP:
+		tel, err = telemetry.NewOTLP(context.Background(), cfg.OTLPServiceName, cfg.OTLPEndpoint)
+	default:
+		return telemetry.NewNoop()
+	}
+	if err != nil {
+		logger.Error("starting telemetry failed, telemetry disabled", "error", err)
+		return telemetry.NewNoop()
+	}
 
-	fmt.Println("shutting down")
-	os.Exit(0)
+	return tel
 }
 
 func stateGenerator() string {
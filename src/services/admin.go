@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+
+	"github.com/o-mago/spotify-status/src/app_error"
+	"github.com/o-mago/spotify-status/src/domain"
+)
+
+// MaxUsersPage caps pages of users, so one request can't load every user
+const MaxUsersPage = 200
+
+// SearchUsersPage lists the users matching filter, at most MaxUsersPage of
+// them
+func (s services) SearchUsersPage(ctx context.Context, filter domain.UserListFilter) ([]domain.User, error) {
+	if filter.Limit <= 0 || filter.Limit > MaxUsersPage {
+		filter.Limit = MaxUsersPage
+	}
+
+	return s.repositories.SearchUsersPage(ctx, filter)
+}
+
+func (s services) SearchUserBySlackID(ctx context.Context, slackID string) (domain.User, error) {
+	return s.repositories.SearchUserBySlackID(ctx, slackID)
+}
+
+// DisableUser stops updating the user's status on an operator's behalf.
+// The user can enable it again.
+func (s services) DisableUser(ctx context.Context, slackID string) error {
+	user, err := s.repositories.SearchUserBySlackID(ctx, slackID)
+	if err != nil {
+		return err
+	}
+
+	user.Enabled = false
+	changed, err := s.repositories.UpdateUserEnabledBySlackID(ctx, user)
+	if err != nil || !changed {
+		return err
+	}
+
+	return s.audit(ctx, user, domain.AuditUserDisabled, domain.AuditActorAdmin, "")
+}
+
+// ResyncUser polls the user right away, whatever their schedule, and
+// returns them with the result as their last poll. Users who turned the app
+// off are left alone.
+func (s services) ResyncUser(ctx context.Context, slackID string) (domain.User, error) {
+	user, err := s.repositories.SearchUserBySlackID(ctx, slackID)
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	if !user.Enabled {
+		return user, app_error.UserDisabled
+	}
+
+	s.pollUser(ctx, user)
+
+	return s.repositories.SearchUserBySlackID(ctx, slackID)
+}
+
+// PurgeWorkspace removes every user of the workspace, with their listening
+// history, and the workspace's settings. It returns how many users were
+// removed.
+func (s services) PurgeWorkspace(ctx context.Context, slackTeamID string) (int, error) {
+	users, err := s.repositories.SearchUsersBySlackTeamID(ctx, slackTeamID)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, user := range users {
+		err = s.repositories.RemoveListeningEventsBySlackID(ctx, user.SlackUserID)
+		if err != nil {
+			return i, err
+		}
+
+		err = s.repositories.RemoveUserBySlackID(ctx, user.SlackUserID)
+		if err != nil {
+			return i, err
+		}
+
+		err = s.audit(ctx, user, domain.AuditUserRemoved, domain.AuditActorAdmin, "workspace purged")
+		if err != nil {
+			return i + 1, err
+		}
+	}
+
+	return len(users), s.repositories.RemoveWorkspace(ctx, slackTeamID)
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/o-mago/spotify-status/src/app_error"
+	"github.com/o-mago/spotify-status/src/domain"
+)
+
+// The most events an admin query returns
+const maxAuditEvents = 50
+
+// SearchAuditEvents lists the newest events matching filter, at most
+// maxAuditEvents of them
+func (s services) SearchAuditEvents(ctx context.Context, filter domain.AuditEventFilter) ([]domain.AuditEvent, error) {
+	if filter.Limit <= 0 || filter.Limit > maxAuditEvents {
+		filter.Limit = maxAuditEvents
+	}
+
+	return s.repositories.SearchAuditEvents(ctx, filter)
+}
+
+func (s services) PruneAuditEvents(ctx context.Context) error {
+	if s.auditRetention <= 0 {
+		return nil
+	}
+
+	_, err := s.repositories.RemoveAuditEventsBefore(ctx, time.Now().Add(-s.auditRetention))
+
+	return err
+}
+
+// audit appends an event about user to the audit log
+func (s services) audit(ctx context.Context, user domain.User, action, actor, detail string) error {
+	return s.repositories.CreateAuditEvent(ctx, domain.AuditEvent{
+		ID:          uuid.New().String(),
+		SlackUserID: user.SlackUserID,
+		SlackTeamID: user.SlackTeamID,
+		Action:      action,
+		Actor:       actor,
+		Detail:      detail,
+		CreatedAt:   time.Now(),
+	})
+}
+
+// auditStatusWrite records a status the app wrote, or cleared when status is
+// empty. The status is already on the profile, so a failure is only logged.
+func (s services) auditStatusWrite(ctx context.Context, user domain.User, status string) {
+	action := domain.AuditStatusSet
+	if status == "" {
+		action = domain.AuditStatusCleared
+	}
+
+	err := s.audit(ctx, user, action, domain.AuditActorApp, status)
+	if err != nil {
+		s.log(ctx, user.SlackUserID).ErrorContext(ctx, "recording the status write failed", "error", err, "class", app_error.AuditError.Error())
+	}
+}
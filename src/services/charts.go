@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/o-mago/spotify-status/src/app_error"
+	"github.com/o-mago/spotify-status/src/crypto"
+	"github.com/o-mago/spotify-status/src/domain"
+	"github.com/slack-go/slack"
+)
+
+const chartsTopLimit = 10
+
+func (s services) SearchWorkspaceCharts(ctx context.Context, slackTeamID, period string) (domain.ListeningSummary, error) {
+	now := time.Now()
+
+	from := now.AddDate(0, 0, -7)
+	if period == domain.ChartsPeriodMonth {
+		from = now.AddDate(0, -1, 0)
+	}
+
+	return s.workspaceCharts(ctx, slackTeamID, from, now)
+}
+
+func (s services) UpdateWorkspaceCharts(ctx context.Context, workspace domain.Workspace) error {
+	if workspace.ChartsChannel != "" && workspace.ChartsPeriod != domain.ChartsPeriodWeek && workspace.ChartsPeriod != domain.ChartsPeriodMonth {
+		return app_error.ChartsError
+	}
+
+	return s.repositories.UpdateWorkspaceCharts(ctx, workspace)
+}
+
+func (s services) PostWorkspaceCharts(ctx context.Context) error {
+	workspaces, err := s.repositories.SearchChartsWorkspaces(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	for _, workspace := range workspaces {
+		from, until := lastChartsPeriod(workspace.ChartsPeriod, now)
+		if !workspace.ChartsSentAt.Before(until) {
+			continue
+		}
+
+		err = s.postWorkspaceCharts(ctx, workspace, from, until)
+		if err != nil {
+			s.log(ctx, "").ErrorContext(ctx, "posting the charts failed", "error", err, "class", app_error.ChartsError.Error(), "slack_team_id", workspace.SlackTeamID)
+		}
+	}
+
+	return nil
+}
+
+func (s services) postWorkspaceCharts(ctx context.Context, workspace domain.Workspace, from, until time.Time) error {
+	summary, err := s.workspaceCharts(ctx, workspace.SlackTeamID, from, until)
+
+	// Too few listeners would make the charts identify them, so the period
+	// is skipped
+	if errors.Is(err, app_error.NotEnoughListeners) {
+		return s.repositories.UpdateWorkspaceChartsSentAt(ctx, workspace.SlackTeamID, until)
+	}
+	if err != nil {
+		return err
+	}
+
+	botToken, err := s.workspaceBotToken(ctx, workspace.SlackTeamID)
+	if err != nil {
+		return err
+	}
+
+	slackApi := newSlackClient(botToken)
+
+	title := "Last week's charts"
+	if workspace.ChartsPeriod == domain.ChartsPeriodMonth {
+		title = "Last month's charts"
+	}
+
+	_, _, err = slackApi.PostMessageContext(ctx, workspace.ChartsChannel,
+		slack.MsgOptionText(title, false),
+		slack.MsgOptionBlocks(chartsBlocks(title, summary)...),
+	)
+	if err != nil {
+		return err
+	}
+
+	return s.repositories.UpdateWorkspaceChartsSentAt(ctx, workspace.SlackTeamID, until)
+}
+
+func (s services) workspaceCharts(ctx context.Context, slackTeamID string, from, until time.Time) (domain.ListeningSummary, error) {
+	events, err := s.repositories.SearchListeningEvents(ctx, domain.ListeningEventFilter{
+		SlackTeamID: slackTeamID,
+		From:        from,
+		To:          until,
+	})
+	if err != nil {
+		return domain.ListeningSummary{}, err
+	}
+
+	summary := summarizeListeningEvents(events, chartsTopLimit, s.chartsMinListeners)
+	if summary.Listeners < s.chartsMinListeners {
+		return domain.ListeningSummary{}, app_error.NotEnoughListeners
+	}
+
+	return summary, nil
+}
+
+// workspaceBotToken returns the bot token stored by any of the workspace's
+// users, since all of them share the same installation
+func (s services) workspaceBotToken(ctx context.Context, slackTeamID string) (string, error) {
+	users, err := s.repositories.SearchUsersBySlackTeamID(ctx, slackTeamID)
+	if err != nil {
+		return "", err
+	}
+
+	for _, user := range users {
+		if user.SlackBotAccessToken == "" {
+			continue
+		}
+
+		botToken, err := s.crypto.Decrypt(user.SlackBotAccessToken, crypto.AssociatedData(user.ID, slackBotAccessTokenField))
+		if err != nil {
+			return "", err
+		}
+
+		return string(botToken), nil
+	}
+
+	return "", app_error.ChartsError
+}
+
+// lastChartsPeriod returns the latest complete week (starting on Monday) or
+// month in UTC
+func lastChartsPeriod(period string, now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+
+	if period == domain.ChartsPeriodMonth {
+		until := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return until.AddDate(0, -1, 0), until
+	}
+
+	daysSinceMonday := (int(now.Weekday()) + 6) % 7
+	until := time.Date(now.Year(), now.Month(), now.Day()-daysSinceMonday, 0, 0, 0, 0, time.UTC)
+
+	return until.AddDate(0, 0, -7), until
+}
+
+func chartsBlocks(title string, summary domain.ListeningSummary) []slack.Block {
+	blocks := []slack.Block{
+		slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, title, true, false)),
+		slack.NewContextBlock("",
+			slack.NewTextBlockObject(slack.MarkdownType, fmt.Sprintf(":spotify: %d listeners • %s of listening", summary.Listeners, formatListeningTime(summary.TotalTime)), false, false),
+		),
+	}
+
+	for _, section := range ChartsSections(summary) {
+		blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, section, false, false), nil, nil))
+	}
+
+	return blocks
+}
+
+// ChartsSections formats the top artists, tracks and genres of a workspace,
+// the same whether posted to its channel or asked with a command
+func ChartsSections(summary domain.ListeningSummary) []string {
+	return []string{
+		"*Top artists*\n" + chartText(summary.TopArtists),
+		"*Top tracks*\n" + chartText(summary.TopTracks),
+		"*Top genres*\n" + chartText(summary.TopGenres),
+	}
+}
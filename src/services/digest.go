@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/o-mago/spotify-status/src/app_error"
+	"github.com/o-mago/spotify-status/src/domain"
+	"github.com/slack-go/slack"
+)
+
+const digestTopLimit = 5
+
+const digestPeriod = 7 * 24 * time.Hour
+
+// A digest whose scheduled time was missed by more than this is skipped
+// until the next week
+const digestGracePeriod = 24 * time.Hour
+
+func (s services) UpdateUserWeeklyDigestBySlackID(ctx context.Context, user domain.User) error {
+	return s.repositories.UpdateUserWeeklyDigestBySlackID(ctx, user)
+}
+
+func (s services) UpdateUserDigestScheduleBySlackID(ctx context.Context, user domain.User) error {
+	if user.DigestWeekday < time.Sunday || user.DigestWeekday > time.Saturday {
+		return app_error.InvalidDigestSchedule
+	}
+
+	if user.DigestMinute < 0 || user.DigestMinute >= 24*60 {
+		return app_error.InvalidDigestSchedule
+	}
+
+	_, err := time.LoadLocation(user.Timezone)
+	if err != nil {
+		return app_error.InvalidDigestSchedule
+	}
+
+	return s.repositories.UpdateUserDigestScheduleBySlackID(ctx, user)
+}
+
+func (s services) SendWeeklyDigests(ctx context.Context) error {
+	users, err := s.repositories.SearchDigestUsers(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	for _, user := range users {
+		scheduledAt := lastDigestSchedule(user, now)
+		if !user.DigestSentAt.Before(scheduledAt) || now.Sub(scheduledAt) > digestGracePeriod {
+			continue
+		}
+
+		err = s.sendWeeklyDigest(ctx, user, scheduledAt)
+		if err != nil {
+			s.log(ctx, user.SlackUserID).ErrorContext(ctx, "sending the digest failed", "error", err, "class", app_error.DigestError.Error())
+		}
+	}
+
+	return nil
+}
+
+func (s services) sendWeeklyDigest(ctx context.Context, user domain.User, until time.Time) error {
+	user, err := s.decryptUserTokens(user)
+	if err != nil {
+		return err
+	}
+
+	if user.SlackBotAccessToken == "" {
+		return app_error.DigestError
+	}
+
+	from := until.Add(-digestPeriod)
+
+	events, err := s.repositories.SearchListeningEvents(ctx, domain.ListeningEventFilter{
+		SlackUserID: user.SlackUserID,
+		From:        from,
+		To:          until,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Nothing to tell, but the week is still considered done
+	if len(events) > 0 {
+		summary := summarizeListeningEvents(events, digestTopLimit, 1)
+
+		slackApi := newSlackClient(user.SlackBotAccessToken)
+
+		// Posting to the user ID delivers the message in the app's DM
+		_, _, err = slackApi.PostMessageContext(ctx, user.SlackUserID,
+			slack.MsgOptionText("Your week in music: "+formatListeningTime(summary.TotalTime)+" of listening", false),
+			slack.MsgOptionBlocks(digestBlocks(summary, from, until)...),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.repositories.UpdateUserDigestSentAtBySlackID(ctx, user.SlackUserID, time.Now())
+}
+
+// lastDigestSchedule returns the latest digest time, in the user's timezone,
+// that is not after now
+func lastDigestSchedule(user domain.User, now time.Time) time.Time {
+	location, err := time.LoadLocation(user.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+
+	local := now.In(location)
+	daysSince := (int(local.Weekday()) - int(user.DigestWeekday) + 7) % 7
+
+	scheduledAt := time.Date(local.Year(), local.Month(), local.Day()-daysSince, 0, user.DigestMinute, 0, 0, location)
+	if scheduledAt.After(now) {
+		scheduledAt = scheduledAt.AddDate(0, 0, -7)
+	}
+
+	return scheduledAt
+}
+
+func digestBlocks(summary domain.ListeningSummary, from, until time.Time) []slack.Block {
+	period := from.Format("Jan 2") + " - " + until.Add(-time.Second).Format("Jan 2")
+
+	return []slack.Block{
+		slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, "Your week in music", true, false)),
+		slack.NewContextBlock("",
+			slack.NewTextBlockObject(slack.MarkdownType, ":spotify: "+period+" • "+formatListeningTime(summary.TotalTime)+" of listening", false, false),
+		),
+		slack.NewSectionBlock(
+			slack.NewTextBlockObject(slack.MarkdownType, "*Top tracks*\n"+chartText(summary.TopTracks), false, false),
+			nil,
+			nil,
+		),
+		slack.NewSectionBlock(
+			slack.NewTextBlockObject(slack.MarkdownType, "*Top artists*\n"+chartText(summary.TopArtists), false, false),
+			nil,
+			nil,
+		),
+		slack.NewContextBlock("",
+			slack.NewTextBlockObject(slack.MarkdownType, "Use `/spotify-status digest off` to stop receiving this message", false, false),
+		),
+	}
+}
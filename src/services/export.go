@@ -0,0 +1,255 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/o-mago/spotify-status/src/app_error"
+	"github.com/o-mago/spotify-status/src/crypto"
+	"github.com/o-mago/spotify-status/src/domain"
+	"github.com/slack-go/slack"
+)
+
+const exportFilename = "spotify-status-export.json"
+
+// Stored tokens are listed in exports, but never their value
+const redactedToken = "[redacted]"
+
+// Exports are sent from a slash command, so they hold at most the latest
+// events of each kind, as stated in the file
+const (
+	exportListeningEventsLimit = 5000
+	exportAuditEventsLimit     = 1000
+)
+
+// SearchUserExport gathers everything stored about the user
+func (s services) SearchUserExport(ctx context.Context, slackUserID string) (domain.UserExport, error) {
+	user, err := s.repositories.SearchUserBySlackID(ctx, slackUserID)
+	if err != nil {
+		return domain.UserExport{}, err
+	}
+
+	workspace, err := s.repositories.SearchWorkspace(ctx, user.SlackTeamID)
+	if err != nil && !errors.Is(err, app_error.WorkspaceNotFound) {
+		return domain.UserExport{}, err
+	}
+
+	events, err := s.repositories.SearchListeningEvents(ctx, domain.ListeningEventFilter{
+		SlackUserID: slackUserID,
+		Limit:       exportListeningEventsLimit,
+	})
+	if err != nil {
+		return domain.UserExport{}, err
+	}
+
+	auditEvents, err := s.repositories.SearchAuditEvents(ctx, domain.AuditEventFilter{
+		SlackUserID: slackUserID,
+		Limit:       exportAuditEventsLimit,
+	})
+	if err != nil {
+		return domain.UserExport{}, err
+	}
+
+	return domain.UserExport{
+		User:            user,
+		Workspace:       workspace,
+		ListeningEvents: events,
+		AuditEvents:     auditEvents,
+		ExportedAt:      time.Now(),
+	}, nil
+}
+
+// SendUserExport sends the user a direct message with their export as a
+// JSON file
+func (s services) SendUserExport(ctx context.Context, slackUserID string) error {
+	export, err := s.SearchUserExport(ctx, slackUserID)
+	if err != nil {
+		return err
+	}
+
+	content, err := json.MarshalIndent(newExportDocument(export), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	user := export.User
+	if user.SlackBotAccessToken == "" {
+		return app_error.ExportError
+	}
+
+	botToken, err := s.crypto.Decrypt(user.SlackBotAccessToken, crypto.AssociatedData(user.ID, slackBotAccessTokenField))
+	if err != nil {
+		return err
+	}
+
+	slackApi := newSlackClient(string(botToken))
+
+	channel, _, _, err := slackApi.OpenConversationContext(ctx, &slack.OpenConversationParameters{Users: []string{user.SlackUserID}})
+	if err != nil {
+		return err
+	}
+
+	_, err = slackApi.UploadFileContext(ctx, slack.FileUploadParameters{
+		Content:        string(content),
+		Filetype:       "json",
+		Filename:       exportFilename,
+		Title:          "Your Spotify Status data",
+		InitialComment: "Here is everything Spotify Status stores about you. Your Slack and Spotify tokens are listed, but not their value.",
+		Channels:       []string{channel.ID},
+	})
+
+	return err
+}
+
+type exportDocument struct {
+	ExportedAt       time.Time              `json:"exported_at"`
+	Limits           exportLimits           `json:"limits"`
+	User             exportUser             `json:"user"`
+	Workspace        exportWorkspace        `json:"workspace"`
+	ListeningHistory []exportListeningEvent `json:"listening_history"`
+	AuditEvents      []exportAuditEvent     `json:"audit_events"`
+}
+
+// exportLimits are the most events of each kind an export holds, the latest
+// ones
+type exportLimits struct {
+	ListeningHistory int `json:"listening_history"`
+	AuditEvents      int `json:"audit_events"`
+}
+
+type exportUser struct {
+	SlackUserID      string            `json:"slack_user_id"`
+	SlackTeamID      string            `json:"slack_team_id"`
+	Tokens           map[string]string `json:"tokens"`
+	SpotifyExpiry    time.Time         `json:"spotify_token_expiry"`
+	Enabled          bool              `json:"enabled"`
+	ListeningHistory bool              `json:"listening_history"`
+	WeeklyDigest     bool              `json:"weekly_digest"`
+	DigestWeekday    string            `json:"digest_weekday"`
+	DigestMinute     int               `json:"digest_minute"`
+	Timezone         string            `json:"timezone"`
+	DigestSentAt     time.Time         `json:"digest_sent_at"`
+	TeamVisible      bool              `json:"team_visible"`
+	NowPlaying       exportNowPlaying  `json:"now_playing"`
+	NextPollAt       time.Time         `json:"next_poll_at"`
+	LastPlayedAt     time.Time         `json:"last_played_at"`
+	LastPollAt       time.Time         `json:"last_poll_at"`
+	LastPollOutcome  string            `json:"last_poll_outcome"`
+	LastPollError    string            `json:"last_poll_error,omitempty"`
+	CreatedAt        time.Time         `json:"created_at"`
+	UpdatedAt        time.Time         `json:"updated_at"`
+}
+
+type exportNowPlaying struct {
+	TrackID string    `json:"track_id"`
+	Track   string    `json:"track"`
+	Artists string    `json:"artists"`
+	Until   time.Time `json:"until"`
+}
+
+type exportWorkspace struct {
+	SlackTeamID   string    `json:"slack_team_id"`
+	AllowTeamView bool      `json:"allow_team_view"`
+	ChartsChannel string    `json:"charts_channel"`
+	ChartsPeriod  string    `json:"charts_period"`
+	ChartsSentAt  time.Time `json:"charts_sent_at"`
+}
+
+type exportListeningEvent struct {
+	TrackID   string    `json:"track_id"`
+	TrackName string    `json:"track_name"`
+	Artists   []string  `json:"artists"`
+	Album     string    `json:"album"`
+	Genres    []string  `json:"genres"`
+	Duration  string    `json:"duration"`
+	StartedAt time.Time `json:"started_at"`
+	EndedAt   time.Time `json:"ended_at"`
+}
+
+type exportAuditEvent struct {
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	Detail    string    `json:"detail,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newExportDocument(export domain.UserExport) exportDocument {
+	user := export.User
+
+	tokens := map[string]string{}
+	for _, token := range userTokens(&user) {
+		if *token.value != "" {
+			tokens[token.field] = redactedToken
+		}
+	}
+
+	events := make([]exportListeningEvent, len(export.ListeningEvents))
+	for i, event := range export.ListeningEvents {
+		events[i] = exportListeningEvent{
+			TrackID:   event.TrackID,
+			TrackName: event.TrackName,
+			Artists:   event.Artists,
+			Album:     event.Album,
+			Genres:    event.Genres,
+			Duration:  event.Duration.String(),
+			StartedAt: event.StartedAt,
+			EndedAt:   event.EndedAt,
+		}
+	}
+
+	auditEvents := make([]exportAuditEvent, len(export.AuditEvents))
+	for i, event := range export.AuditEvents {
+		auditEvents[i] = exportAuditEvent{
+			Action:    event.Action,
+			Actor:     event.Actor,
+			Detail:    event.Detail,
+			CreatedAt: event.CreatedAt,
+		}
+	}
+
+	return exportDocument{
+		ExportedAt: export.ExportedAt,
+		Limits: exportLimits{
+			ListeningHistory: exportListeningEventsLimit,
+			AuditEvents:      exportAuditEventsLimit,
+		},
+		User: exportUser{
+			SlackUserID:      user.SlackUserID,
+			SlackTeamID:      user.SlackTeamID,
+			Tokens:           tokens,
+			SpotifyExpiry:    user.SpotifyExpiry,
+			Enabled:          user.Enabled,
+			ListeningHistory: user.ListeningHistory,
+			WeeklyDigest:     user.WeeklyDigest,
+			DigestWeekday:    user.DigestWeekday.String(),
+			DigestMinute:     user.DigestMinute,
+			Timezone:         user.Timezone,
+			DigestSentAt:     user.DigestSentAt,
+			TeamVisible:      user.TeamVisible,
+			NowPlaying: exportNowPlaying{
+				TrackID: user.NowPlayingTrackID,
+				Track:   user.NowPlayingTrack,
+				Artists: user.NowPlayingArtists,
+				Until:   user.NowPlayingUntil,
+			},
+			NextPollAt:      user.NextPollAt,
+			LastPlayedAt:    user.LastPlayedAt,
+			LastPollAt:      user.LastPollAt,
+			LastPollOutcome: user.LastPollOutcome,
+			LastPollError:   user.LastPollError,
+			CreatedAt:       user.CreatedAt,
+			UpdatedAt:       user.UpdatedAt,
+		},
+		Workspace: exportWorkspace{
+			SlackTeamID:   export.Workspace.SlackTeamID,
+			AllowTeamView: export.Workspace.AllowTeamView,
+			ChartsChannel: export.Workspace.ChartsChannel,
+			ChartsPeriod:  export.Workspace.ChartsPeriod,
+			ChartsSentAt:  export.Workspace.ChartsSentAt,
+		},
+		ListeningHistory: events,
+		AuditEvents:      auditEvents,
+	}
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/o-mago/spotify-status/src/app_error"
+	"github.com/o-mago/spotify-status/src/crypto"
+	"github.com/o-mago/spotify-status/src/domain"
+	"github.com/o-mago/spotify-status/src/logging"
+)
+
+// RotateKeys re-seals the tokens of every user, disabled ones included,
+// sealed with another key than the active one or without their associated
+// data, one batch at a time. Users already rotated are skipped, so an
+// interrupted rotation can be run again. It returns how many users were
+// rotated.
+func (s services) RotateKeys(ctx context.Context) (int, error) {
+	rotated := 0
+
+	err := s.repositories.SearchUsersInBatches(ctx, domain.UserPollFilter{IncludeDisabled: true}, pollBatchSize, func(users []domain.User) error {
+		for _, user := range users {
+			rotatedUser, changed, err := s.rotateUserTokens(user)
+			// Most likely a key missing from the ring, which would fail
+			// every other user too
+			if err != nil {
+				return fmt.Errorf("user %s: %w", logging.HashID(user.SlackUserID), err)
+			}
+			if !changed {
+				continue
+			}
+
+			err = s.repositories.UpdateUserTokensBySlackID(ctx, rotatedUser)
+			// Removed since the batch was read
+			if errors.Is(err, app_error.UserNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			rotated++
+		}
+
+		s.log(ctx, "").InfoContext(ctx, "rotated a batch of users", "rotated", rotated)
+		return ctx.Err()
+	})
+
+	return rotated, err
+}
+
+func (s services) rotateUserTokens(user domain.User) (domain.User, bool, error) {
+	changed := false
+
+	for _, token := range userTokens(&user) {
+		// Users who installed the app before the bot token was stored have none
+		if *token.value == "" || !s.crypto.NeedsRotation(*token.value) {
+			continue
+		}
+
+		associatedData := crypto.AssociatedData(user.ID, token.field)
+
+		value, err := s.crypto.Decrypt(*token.value, associatedData)
+		if err != nil {
+			return domain.User{}, false, err
+		}
+
+		*token.value, err = s.crypto.Encrypt(string(value), associatedData)
+		if err != nil {
+			return domain.User{}, false, err
+		}
+		changed = true
+	}
+
+	return user, changed, nil
+}
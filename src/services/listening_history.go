@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/o-mago/spotify-status/src/app_error"
+	"github.com/o-mago/spotify-status/src/domain"
+	"github.com/o-mago/spotify-status/src/metrics"
+	"github.com/zmb3/spotify"
+)
+
+func (s services) UpdateUserListeningHistoryBySlackID(ctx context.Context, user domain.User) error {
+	err := s.repositories.UpdateUserListeningHistoryBySlackID(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	// Opting out of the history also forgets what was recorded so far
+	if !user.ListeningHistory {
+		return s.repositories.RemoveListeningEventsBySlackID(ctx, user.SlackUserID)
+	}
+
+	return nil
+}
+
+func (s services) PruneListeningEvents(ctx context.Context) error {
+	if s.listeningHistoryRetention <= 0 {
+		return nil
+	}
+
+	_, err := s.repositories.RemoveListeningEventsBefore(ctx, time.Now().Add(-s.listeningHistoryRetention))
+
+	return err
+}
+
+// recordListeningEvent closes the user's open event when the track changes or
+// playback stops, and opens a new one for the track being played
+func (s services) recordListeningEvent(ctx context.Context, user domain.User, spotifyApi spotify.Client, player *spotify.CurrentlyPlaying) error {
+	now := time.Now()
+	playing := player != nil && player.Item != nil && player.Playing
+
+	openEvent, err := s.repositories.SearchOpenListeningEvent(ctx, user.SlackUserID)
+	if err != nil && !errors.Is(err, app_error.ListeningEventNotFound) {
+		return err
+	}
+
+	if err == nil {
+		if playing && openEvent.TrackID == string(player.Item.ID) {
+			return nil
+		}
+
+		err = s.repositories.EndListeningEvent(ctx, openEvent.ID, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !playing {
+		return nil
+	}
+
+	track := newTrackFromSpotify(player)
+
+	// Genres only feed the charts, so the event is recorded without them
+	// rather than lost
+	genres, err := trackGenres(spotifyApi, player.Item)
+	if err != nil {
+		genres = []string{}
+		s.log(ctx, user.SlackUserID).WarnContext(ctx, "fetching the track genres failed", "error", err, "class", metrics.SpotifyError)
+	}
+
+	return s.repositories.CreateListeningEvent(ctx, domain.ListeningEvent{
+		ID:          uuid.New().String(),
+		SlackUserID: user.SlackUserID,
+		SlackTeamID: user.SlackTeamID,
+		TrackID:     track.ID,
+		TrackName:   track.Name,
+		Artists:     track.Artists,
+		Album:       track.Album,
+		Genres:      genres,
+		Duration:    track.Duration,
+		StartedAt:   now.Add(-track.Progress),
+	})
+}
+
+// trackGenres returns the genres of the track's artists, as Spotify only
+// classifies artists
+func trackGenres(spotifyApi spotify.Client, track *spotify.FullTrack) ([]string, error) {
+	artistIDs := make([]spotify.ID, 0, len(track.Artists))
+	for _, artist := range track.Artists {
+		if artist.ID != "" {
+			artistIDs = append(artistIDs, artist.ID)
+		}
+	}
+
+	if len(artistIDs) == 0 {
+		return []string{}, nil
+	}
+
+	artists, err := spotifyApi.GetArtists(artistIDs...)
+	if err != nil {
+		return []string{}, err
+	}
+
+	seen := map[string]bool{}
+	genres := []string{}
+	for _, artist := range artists {
+		if artist == nil {
+			continue
+		}
+
+		for _, genre := range artist.Genres {
+			if !seen[genre] {
+				seen[genre] = true
+				genres = append(genres, genre)
+			}
+		}
+	}
+
+	return genres, nil
+}
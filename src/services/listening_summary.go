@@ -0,0 +1,125 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/o-mago/spotify-status/src/domain"
+)
+
+type chartCounter struct {
+	plays     int
+	listeners map[string]bool
+}
+
+// summarizeListeningEvents aggregates events into plays and listening time,
+// keeping the top entries of each chart heard by at least minListeners users
+func summarizeListeningEvents(events []domain.ListeningEvent, limit, minListeners int) domain.ListeningSummary {
+	tracks := map[string]*chartCounter{}
+	artists := map[string]*chartCounter{}
+	genres := map[string]*chartCounter{}
+	listeners := map[string]bool{}
+	var totalTime time.Duration
+
+	for _, event := range events {
+		listeners[event.SlackUserID] = true
+		totalTime += listenedTime(event)
+
+		trackName := event.TrackName
+		if len(event.Artists) > 0 {
+			trackName += " - " + event.Artists[0]
+		}
+		countPlay(tracks, trackName, event.SlackUserID)
+
+		for _, artist := range event.Artists {
+			countPlay(artists, artist, event.SlackUserID)
+		}
+
+		for _, genre := range event.Genres {
+			countPlay(genres, genre, event.SlackUserID)
+		}
+	}
+
+	return domain.ListeningSummary{
+		TopTracks:  topChartEntries(tracks, limit, minListeners),
+		TopArtists: topChartEntries(artists, limit, minListeners),
+		TopGenres:  topChartEntries(genres, limit, minListeners),
+		TotalTime:  totalTime,
+		Listeners:  len(listeners),
+	}
+}
+
+func listenedTime(event domain.ListeningEvent) time.Duration {
+	if event.EndedAt.IsZero() {
+		return event.Duration
+	}
+
+	listened := event.EndedAt.Sub(event.StartedAt)
+	if event.Duration > 0 && listened > event.Duration {
+		return event.Duration
+	}
+
+	return listened
+}
+
+func countPlay(counters map[string]*chartCounter, name, slackUserID string) {
+	counter, ok := counters[name]
+	if !ok {
+		counter = &chartCounter{listeners: map[string]bool{}}
+		counters[name] = counter
+	}
+
+	counter.plays++
+	counter.listeners[slackUserID] = true
+}
+
+func topChartEntries(counters map[string]*chartCounter, limit, minListeners int) []domain.ChartEntry {
+	entries := make([]domain.ChartEntry, 0, len(counters))
+	for name, counter := range counters {
+		if len(counter.listeners) < minListeners {
+			continue
+		}
+
+		entries = append(entries, domain.ChartEntry{
+			Name:      name,
+			Plays:     counter.plays,
+			Listeners: len(counter.listeners),
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Plays != entries[j].Plays {
+			return entries[i].Plays > entries[j].Plays
+		}
+		if entries[i].Listeners != entries[j].Listeners {
+			return entries[i].Listeners > entries[j].Listeners
+		}
+		return entries[i].Name < entries[j].Name
+	})
+
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	return entries
+}
+
+func chartText(entries []domain.ChartEntry) string {
+	if len(entries) == 0 {
+		return "_Not enough plays yet_"
+	}
+
+	lines := make([]string, len(entries))
+	for i, entry := range entries {
+		lines[i] = fmt.Sprintf("%d. %s (%d plays)", i+1, entry.Name, entry.Plays)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func formatListeningTime(d time.Duration) string {
+	d = d.Round(time.Minute)
+	return fmt.Sprintf("%dh %dm", int(d.Hours()), int(d.Minutes())%60)
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/o-mago/spotify-status/src/domain"
+	"github.com/zmb3/spotify"
+)
+
+const (
+	// Playing users are polled again when their track should end, but at
+	// least this often so skips and pauses show up quickly
+	maxPlayingPollInterval = 30 * time.Second
+	minPollInterval        = 10 * time.Second
+	// Leaves Spotify time to move on to the next track
+	trackEndMargin = 2 * time.Second
+)
+
+// idlePollIntervals back off polling the longer a user has gone without
+// playing anything, the last one applying from then on
+var idlePollIntervals = []struct {
+	idleFor  time.Duration
+	interval time.Duration
+}{
+	{time.Minute, 10 * time.Second},
+	{10 * time.Minute, time.Minute},
+	{0, 5 * time.Minute},
+}
+
+// schedulePoll stores when user should be polled next, given what they were
+// playing at now. A nil player, as after a failed request, counts as idle.
+func (s services) schedulePoll(ctx context.Context, user domain.User, player *spotify.CurrentlyPlaying, now time.Time) error {
+	if player != nil && player.Item != nil && player.Playing {
+		user.LastPlayedAt = now
+	}
+
+	return s.repositories.UpdateUserPollScheduleBySlackID(ctx, domain.User{
+		SlackUserID:  user.SlackUserID,
+		NextPollAt:   now.Add(nextPollInterval(user.LastPlayedAt, player, now)),
+		LastPlayedAt: user.LastPlayedAt,
+	})
+}
+
+func nextPollInterval(lastPlayedAt time.Time, player *spotify.CurrentlyPlaying, now time.Time) time.Duration {
+	if player != nil && player.Item != nil && player.Playing {
+		track := newTrackFromSpotify(player)
+
+		interval := track.Duration - track.Progress + trackEndMargin
+		if interval < minPollInterval {
+			return minPollInterval
+		}
+		if interval > maxPlayingPollInterval {
+			return maxPlayingPollInterval
+		}
+		return interval
+	}
+
+	idleFor := now.Sub(lastPlayedAt)
+	for _, step := range idlePollIntervals {
+		if step.idleFor == 0 || idleFor < step.idleFor {
+			return step.interval
+		}
+	}
+
+	return idlePollIntervals[len(idlePollIntervals)-1].interval
+}
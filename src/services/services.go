@@ -2,144 +2,455 @@ package services
 
 import (
 	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/o-mago/spotify-status/src/app_error"
 	"github.com/o-mago/spotify-status/src/crypto"
 	"github.com/o-mago/spotify-status/src/domain"
+	"github.com/o-mago/spotify-status/src/logging"
+	"github.com/o-mago/spotify-status/src/metrics"
 	"github.com/o-mago/spotify-status/src/repositories"
+	"github.com/o-mago/spotify-status/src/telemetry"
 	"github.com/slack-go/slack"
 	"github.com/zmb3/spotify"
 	"golang.org/x/oauth2"
 )
 
+// pollBatchSize bounds how many users are loaded, and updated concurrently,
+// at once
+const pollBatchSize = 100
+
 type services struct {
-	repositories         repositories.Repositories
-	spotifyAuthenticator spotify.Authenticator
-	crypto               crypto.Crypto
+	repositories              repositories.Repositories
+	spotifyOAuthConfig        *oauth2.Config
+	crypto                    crypto.Crypto
+	listeningHistoryRetention time.Duration
+	auditRetention            time.Duration
+	chartsMinListeners        int
+	logger                    *slog.Logger
 }
 
 type Services interface {
 	AddUser(ctx context.Context, user domain.User) error
-	ChangeUserStatus(ctx context.Context) error
+	ChangeUserStatus(ctx context.Context, shard domain.UserShard) error
+	ClearUserStatuses(ctx context.Context) error
 	RemoveUserBySlackID(ctx context.Context, slackID string) error
 	UpdateUserEnabledBySlackID(ctx context.Context, user domain.User) error
+	UpdateUserListeningHistoryBySlackID(ctx context.Context, user domain.User) error
+	PruneListeningEvents(ctx context.Context) error
+	UpdateUserWeeklyDigestBySlackID(ctx context.Context, user domain.User) error
+	UpdateUserDigestScheduleBySlackID(ctx context.Context, user domain.User) error
+	SendWeeklyDigests(ctx context.Context) error
+	ShareCurrentTrack(ctx context.Context, slackUserID, channelID string) error
+	SearchTeamNowPlaying(ctx context.Context, slackTeamID string) ([]domain.User, error)
+	UpdateUserTeamVisibleBySlackID(ctx context.Context, user domain.User) error
+	UpdateWorkspaceTeamView(ctx context.Context, workspace domain.Workspace) error
+	IsWorkspaceAdmin(ctx context.Context, slackUserID, slackTeamID string) (bool, error)
+	SearchWorkspaceCharts(ctx context.Context, slackTeamID, period string) (domain.ListeningSummary, error)
+	UpdateWorkspaceCharts(ctx context.Context, workspace domain.Workspace) error
+	PostWorkspaceCharts(ctx context.Context) error
+	RotateKeys(ctx context.Context) (int, error)
+	SearchUserExport(ctx context.Context, slackUserID string) (domain.UserExport, error)
+	SendUserExport(ctx context.Context, slackUserID string) error
+	SearchAuditEvents(ctx context.Context, filter domain.AuditEventFilter) ([]domain.AuditEvent, error)
+	PruneAuditEvents(ctx context.Context) error
+	SearchUsersPage(ctx context.Context, filter domain.UserListFilter) ([]domain.User, error)
+	SearchUserBySlackID(ctx context.Context, slackID string) (domain.User, error)
+	DisableUser(ctx context.Context, slackID string) error
+	ResyncUser(ctx context.Context, slackID string) (domain.User, error)
+	PurgeWorkspace(ctx context.Context, slackTeamID string) (int, error)
 }
 
-func NewServices(repositories repositories.Repositories, spotifyAuthenticator spotify.Authenticator, crypto crypto.Crypto) Services {
+func NewServices(repositories repositories.Repositories, spotifyOAuthConfig *oauth2.Config, crypto crypto.Crypto,
+	listeningHistoryRetention, auditRetention time.Duration, chartsMinListeners int, logger *slog.Logger) Services {
 	return services{
 		repositories,
-		spotifyAuthenticator,
+		spotifyOAuthConfig,
 		crypto,
+		listeningHistoryRetention,
+		auditRetention,
+		chartsMinListeners,
+		logger,
+	}
+}
+
+// log returns the logger of ctx, carrying its request ID if any, with the
+// hashed Slack user ID the entries are about
+func (s services) log(ctx context.Context, slackUserID string) *slog.Logger {
+	logger := logging.FromContext(ctx, s.logger)
+	if slackUserID == "" {
+		return logger
 	}
+
+	return logger.With("slack_user", logging.HashID(slackUserID))
 }
 
 func (s services) AddUser(ctx context.Context, user domain.User) error {
 	user.ID = uuid.New().String()
 
-	encSpotifyAccessToken, err := s.crypto.Encrypt(user.SpotifyAccessToken)
+	for _, token := range userTokens(&user) {
+		encToken, err := s.crypto.Encrypt(*token.value, crypto.AssociatedData(user.ID, token.field))
+		if err != nil {
+			return err
+		}
+
+		*token.value = encToken
+	}
+
+	err := s.repositories.CreateUser(ctx, user)
 	if err != nil {
 		return err
 	}
 
-	encSpotifyRefreshToken, err := s.crypto.Encrypt(user.SpotifyRefreshToken)
+	return s.audit(ctx, user, domain.AuditUserAdded, user.SlackUserID, "")
+}
+
+// RemoveUserBySlackID removes the user and their listening history. Their
+// audit events are kept, as proof of their consent, until the retention
+// removes them.
+func (s services) RemoveUserBySlackID(ctx context.Context, id string) error {
+	user, err := s.repositories.SearchUserBySlackID(ctx, id)
 	if err != nil {
 		return err
 	}
 
-	encSlackAccessToken, err := s.crypto.Encrypt(user.SlackAccessToken)
+	err = s.repositories.RemoveListeningEventsBySlackID(ctx, id)
 	if err != nil {
 		return err
 	}
 
-	user.SlackAccessToken = encSlackAccessToken
-	user.SpotifyAccessToken = encSpotifyAccessToken
-	user.SpotifyRefreshToken = encSpotifyRefreshToken
+	err = s.repositories.RemoveUserBySlackID(ctx, id)
+	if err != nil {
+		return err
+	}
 
-	return s.repositories.CreateUser(ctx, user)
+	return s.audit(ctx, user, domain.AuditUserRemoved, id, "")
 }
 
-func (s services) RemoveUserBySlackID(ctx context.Context, id string) error {
-	return s.repositories.RemoveUserBySlackID(ctx, id)
+// UpdateUserEnabledBySlackID audits the change only when Enabled flips
+func (s services) UpdateUserEnabledBySlackID(ctx context.Context, user domain.User) error {
+	changed, err := s.repositories.UpdateUserEnabledBySlackID(ctx, user)
+	if err != nil || !changed {
+		return err
+	}
+
+	action := domain.AuditUserDisabled
+	if user.Enabled {
+		action = domain.AuditUserEnabled
+	}
+
+	return s.audit(ctx, user, action, user.SlackUserID, "")
 }
 
-func (s services) UpdateUserEnabledBySlackID(ctx context.Context, user domain.User) error {
-	return s.repositories.UpdateUserEnabledBySlackID(ctx, user)
+// ChangeUserStatus updates the enabled users of shard due for a poll, one
+// batch at a time, each batch concurrently, and returns once all of them are
+// done, so ctx bounds the whole tick
+func (s services) ChangeUserStatus(ctx context.Context, shard domain.UserShard) error {
+	start := time.Now()
+	defer func() { metrics.ObservePollTick(time.Since(start)) }()
+
+	filter := domain.UserPollFilter{Shard: shard, DueAt: start}
+
+	return s.repositories.SearchUsersInBatches(ctx, filter, pollBatchSize, func(users []domain.User) error {
+		s.changeUserStatuses(ctx, users)
+		return ctx.Err()
+	})
 }
 
-func (s services) ChangeUserStatus(ctx context.Context) error {
-	users, err := s.repositories.SearchUsers(ctx)
+func (s services) changeUserStatuses(ctx context.Context, users []domain.User) {
+	var wg sync.WaitGroup
+	for _, user := range users {
+		wg.Add(1)
+		go func(user domain.User) {
+			defer wg.Done()
+
+			s.pollUser(ctx, user)
+		}(user)
+	}
+	wg.Wait()
+}
+
+// pollUser updates the user's status from what they are playing, and stores
+// how it went as their last poll
+func (s services) pollUser(ctx context.Context, encUser domain.User) {
+	ctx, span := telemetry.StartSpan(ctx, "ChangeUserStatus/user")
+	defer span.End()
+	span.SetAttribute("slack_user", logging.HashID(encUser.SlackUserID))
+
+	logger := s.log(ctx, encUser.SlackUserID)
+
+	user, err := s.decryptUserTokens(encUser)
 	if err != nil {
-		return err
+		span.RecordError(err)
+		s.countPoll(ctx, encUser, metrics.Error, metrics.DecryptError, err)
+		logger.ErrorContext(ctx, "decrypting tokens failed", "error", err, "class", metrics.DecryptError)
+
+		// Backs off as if nothing was playing, instead of retrying every tick
+		scheduleErr := s.schedulePoll(ctx, encUser, nil, time.Now())
+		if scheduleErr != nil {
+			logger.ErrorContext(ctx, "scheduling the next poll failed", "error", scheduleErr, "class", app_error.PollScheduleError.Error())
+		}
+		return
 	}
 
-	for _, user := range users {
-		go func(user domain.User) {
-			decSpotifyAccessToken, err := s.crypto.Decrypt(user.SpotifyAccessToken)
-			if err != nil {
-				return
-			}
-
-			decSpotifyRefreshToken, err := s.crypto.Decrypt(user.SpotifyRefreshToken)
-			if err != nil {
-				return
-			}
-
-			decSlackAccessToken, err := s.crypto.Decrypt(user.SlackAccessToken)
-			if err != nil {
-				return
-			}
-
-			slackApi := slack.New(string(decSlackAccessToken))
-
-			spotifyToken := oauth2.Token{
-				AccessToken:  string(decSpotifyAccessToken),
-				RefreshToken: string(decSpotifyRefreshToken),
-				Expiry:       user.SpotifyExpiry,
-				TokenType:    user.SpotifyTokenType,
-			}
-			spotifyApi := s.spotifyAuthenticator.NewClient(&spotifyToken)
-
-			player, err := spotifyApi.PlayerCurrentlyPlaying()
-			if err != nil {
-				return
-			}
-
-			if player == nil || player.Item == nil {
-				return
-			}
-
-			profile, err := slackApi.GetUserProfile(&slack.GetUserProfileParameters{UserID: user.SlackUserID})
-			if err != nil {
-				return
-			}
-
-			canUpdateStatus := player.Playing && (profile.StatusEmoji == ":spotify:" || profile.StatusEmoji == "")
-			canClearStatus := !player.Playing && profile.StatusEmoji == ":spotify:"
-			if !canUpdateStatus && !canClearStatus {
-				return
-			}
-
-			if canUpdateStatus {
-				songName := player.Item.Name
-				slackStatus := songName + " - " + player.Item.Artists[0].Name
-				if len(slackStatus) > 100 {
-					extraChars := len(slackStatus) - 100 + 3
-					songName = player.Item.Name[:len(player.Item.Name)-extraChars]
-					slackStatus = songName + "... - " + player.Item.Artists[0].Name
+	slackApi := newSlackClient(user.SlackAccessToken)
+	spotifyApi := s.newSpotifyClient(ctx, user)
+
+	if user.SlackTeamID == "" {
+		user, err = s.backfillSlackTeamID(ctx, user, slackApi)
+		if err != nil {
+			logger.WarnContext(ctx, "backfilling the Slack team ID failed", "error", err, "class", metrics.SlackError)
+		}
+	}
+
+	player, err := spotifyApi.PlayerCurrentlyPlaying()
+
+	scheduleErr := s.schedulePoll(ctx, user, player, time.Now())
+	if scheduleErr != nil {
+		logger.ErrorContext(ctx, "scheduling the next poll failed", "error", scheduleErr, "class", app_error.PollScheduleError.Error())
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		s.countPoll(ctx, user, metrics.Error, metrics.SpotifyError, err)
+		logger.WarnContext(ctx, "fetching the playing track failed", "error", err, "class", metrics.SpotifyError)
+		return
+	}
+
+	err = s.updateNowPlaying(ctx, user, player)
+	if err != nil {
+		logger.ErrorContext(ctx, "updating now playing failed", "error", err, "class", app_error.TeamViewError.Error())
+	}
+
+	if user.ListeningHistory {
+		err = s.recordListeningEvent(ctx, user, spotifyApi, player)
+		if err != nil {
+			logger.ErrorContext(ctx, "recording the listening event failed", "error", err, "class", app_error.ListeningHistoryError.Error())
+		}
+	}
+
+	if player == nil || player.Item == nil {
+		s.countPoll(ctx, user, metrics.Skipped, "", nil)
+		return
+	}
+
+	profile, err := slackApi.GetUserProfileContext(ctx, &slack.GetUserProfileParameters{UserID: user.SlackUserID})
+	if err != nil {
+		span.RecordError(err)
+		s.countPoll(ctx, user, metrics.Error, metrics.SlackError, err)
+		logger.WarnContext(ctx, "fetching the Slack profile failed", "error", err, "class", metrics.SlackError)
+		return
+	}
+
+	canUpdateStatus := player.Playing && (profile.StatusEmoji == ":spotify:" || profile.StatusEmoji == "")
+	canClearStatus := !player.Playing && profile.StatusEmoji == ":spotify:"
+	if !canUpdateStatus && !canClearStatus {
+		s.countPoll(ctx, user, metrics.Skipped, "", nil)
+		return
+	}
+
+	if canUpdateStatus {
+		songName := player.Item.Name
+		slackStatus := songName + " - " + player.Item.Artists[0].Name
+		if len(slackStatus) > 100 {
+			extraChars := len(slackStatus) - 100 + 3
+			songName = player.Item.Name[:len(player.Item.Name)-extraChars]
+			slackStatus = songName + "... - " + player.Item.Artists[0].Name
+		}
+
+		// The same track is polled several times, and only changes are
+		// written and audited
+		if profile.StatusText == slackStatus && profile.StatusEmoji == ":spotify:" {
+			s.countPoll(ctx, user, metrics.Skipped, "", nil)
+			return
+		}
+
+		err = slackApi.SetUserCustomStatusContextWithUser(ctx, user.SlackUserID, slackStatus, ":spotify:", 0)
+		s.countStatusWrite(ctx, user, logger, span, metrics.Updated, err)
+		if err == nil {
+			s.auditStatusWrite(ctx, user, slackStatus)
+		}
+
+		return
+	}
+
+	err = slackApi.SetUserCustomStatusContextWithUser(ctx, user.SlackUserID, "", "", 0)
+	s.countStatusWrite(ctx, user, logger, span, metrics.Cleared, err)
+	if err == nil {
+		s.auditStatusWrite(ctx, user, "")
+	}
+}
+
+func (s services) countStatusWrite(ctx context.Context, user domain.User, logger *slog.Logger, span telemetry.Span, outcome string, err error) {
+	if err != nil {
+		span.RecordError(err)
+		s.countPoll(ctx, user, metrics.Error, metrics.SlackError, err)
+		logger.WarnContext(ctx, "writing the Slack status failed", "error", err, "class", metrics.SlackError, "outcome", outcome)
+		return
+	}
+
+	s.countPoll(ctx, user, outcome, "", nil)
+	logger.DebugContext(ctx, "status written", "outcome", outcome)
+}
+
+// countPoll counts the outcome of the user's poll, failed ones by class, and
+// stores it as their last poll
+func (s services) countPoll(ctx context.Context, user domain.User, outcome, class string, err error) {
+	metrics.CountUserStatusUpdate(outcome, class)
+
+	lastPoll := domain.User{
+		SlackUserID:     user.SlackUserID,
+		LastPollAt:      time.Now(),
+		LastPollOutcome: outcome,
+	}
+	if err != nil {
+		lastPoll.LastPollError = class + ": " + err.Error()
+	}
+
+	storeErr := s.repositories.UpdateUserLastPollBySlackID(ctx, lastPoll)
+	if storeErr != nil {
+		s.log(ctx, user.SlackUserID).ErrorContext(ctx, "storing the last poll failed", "error", storeErr)
+	}
+}
+
+// ClearUserStatuses clears the status of every enabled user still showing a
+// track, for when nothing is going to keep it up to date
+func (s services) ClearUserStatuses(ctx context.Context) error {
+	return s.repositories.SearchUsersInBatches(ctx, domain.UserPollFilter{}, pollBatchSize, func(users []domain.User) error {
+		var wg sync.WaitGroup
+		for _, user := range users {
+			wg.Add(1)
+			go func(user domain.User) {
+				defer wg.Done()
+
+				user, err := s.decryptUserTokens(user)
+				if err != nil {
+					return
+				}
+
+				slackApi := newSlackClient(user.SlackAccessToken)
+
+				profile, err := slackApi.GetUserProfileContext(ctx, &slack.GetUserProfileParameters{UserID: user.SlackUserID})
+				if err != nil || profile.StatusEmoji != ":spotify:" {
+					return
 				}
 
-				slackApi.SetUserCustomStatusWithUser(user.SlackUserID, slackStatus, ":spotify:", 0)
+				err = slackApi.SetUserCustomStatusContextWithUser(ctx, user.SlackUserID, "", "", 0)
+				if err == nil {
+					s.auditStatusWrite(ctx, user, "")
+				}
+			}(user)
+		}
+		wg.Wait()
 
-				return
-			}
+		return ctx.Err()
+	})
+}
 
-			if canClearStatus {
-				slackApi.SetUserCustomStatusWithUser(user.SlackUserID, "", "", 0)
+// Tokens are sealed with their user's ID and column, so a token copied to
+// another row or column doesn't decrypt
+const (
+	slackAccessTokenField    = "slack_access_token"
+	slackBotAccessTokenField = "slack_bot_access_token"
+	spotifyAccessTokenField  = "spotify_access_token"
+	spotifyRefreshTokenField = "spotify_refresh_token"
+)
 
-				return
-			}
-		}(user)
+type userToken struct {
+	field string
+	value *string
+}
+
+func userTokens(user *domain.User) []userToken {
+	return []userToken{
+		{slackAccessTokenField, &user.SlackAccessToken},
+		{slackBotAccessTokenField, &user.SlackBotAccessToken},
+		{spotifyAccessTokenField, &user.SpotifyAccessToken},
+		{spotifyRefreshTokenField, &user.SpotifyRefreshToken},
+	}
+}
+
+func (s services) decryptUserTokens(user domain.User) (domain.User, error) {
+	for _, token := range userTokens(&user) {
+		// Users who installed the app before the bot token was stored have none
+		if *token.value == "" {
+			continue
+		}
+
+		decToken, err := s.crypto.Decrypt(*token.value, crypto.AssociatedData(user.ID, token.field))
+		if err != nil {
+			return domain.User{}, err
+		}
+
+		*token.value = string(decToken)
 	}
 
-	return nil
+	return user, nil
+}
+
+// newSpotifyClient returns a client whose requests, token refreshes included,
+// are bound to ctx and measured. The spotify package doesn't take contexts,
+// so they are attached by the transport.
+func (s services) newSpotifyClient(ctx context.Context, user domain.User) spotify.Client {
+	spotifyToken := oauth2.Token{
+		AccessToken:  user.SpotifyAccessToken,
+		RefreshToken: user.SpotifyRefreshToken,
+		Expiry:       user.SpotifyExpiry,
+		TokenType:    user.SpotifyTokenType,
+	}
+
+	// oauth2 sends both the refreshes and the requests through this client
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{
+		Transport: metrics.InstrumentTransport("spotify", http.DefaultTransport),
+	})
+
+	tokenSource := refreshCountingTokenSource{
+		base:        s.spotifyOAuthConfig.TokenSource(ctx, &spotifyToken),
+		accessToken: &spotifyToken.AccessToken,
+	}
+
+	httpClient := oauth2.NewClient(ctx, tokenSource)
+	httpClient.Transport = contextTransport{ctx: ctx, base: httpClient.Transport}
+
+	return spotify.NewClient(httpClient)
+}
+
+func newSlackClient(token string) *slack.Client {
+	return slack.New(token, slack.OptionHTTPClient(&http.Client{
+		Transport: metrics.InstrumentTransport("slack", http.DefaultTransport),
+	}))
+}
+
+// refreshCountingTokenSource counts the tokens base hands out that differ
+// from the last one, which it only does after refreshing
+type refreshCountingTokenSource struct {
+	base        oauth2.TokenSource
+	accessToken *string
+}
+
+func (s refreshCountingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.base.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	if token.AccessToken != *s.accessToken {
+		*s.accessToken = token.AccessToken
+		metrics.CountSpotifyTokenRefresh()
+	}
+
+	return token, nil
+}
+
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(t.ctx))
 }
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/o-mago/spotify-status/src/app_error"
+	"github.com/o-mago/spotify-status/src/domain"
+	"github.com/slack-go/slack"
+	"github.com/zmb3/spotify"
+)
+
+const progressBarLength = 20
+
+func (s services) ShareCurrentTrack(ctx context.Context, slackUserID, channelID string) error {
+	user, err := s.repositories.SearchUserBySlackID(ctx, slackUserID)
+	if err != nil {
+		return err
+	}
+
+	user, err = s.decryptUserTokens(user)
+	if err != nil {
+		return err
+	}
+
+	if user.SlackBotAccessToken == "" {
+		return app_error.ShareTrackError
+	}
+
+	spotifyApi := s.newSpotifyClient(ctx, user)
+
+	player, err := spotifyApi.PlayerCurrentlyPlaying()
+	if err != nil {
+		return err
+	}
+
+	if player == nil || player.Item == nil || !player.Playing {
+		return app_error.NothingPlaying
+	}
+
+	track := newTrackFromSpotify(player)
+
+	slackApi := newSlackClient(user.SlackBotAccessToken)
+
+	_, _, err = slackApi.PostMessageContext(ctx, channelID,
+		slack.MsgOptionText(fmt.Sprintf("<@%s> is listening to %s - %s", user.SlackUserID, track.Name, strings.Join(track.Artists, ", ")), false),
+		slack.MsgOptionBlocks(shareTrackBlocks(user.SlackUserID, track)...),
+	)
+
+	return err
+}
+
+func newTrackFromSpotify(player *spotify.CurrentlyPlaying) domain.Track {
+	artists := make([]string, len(player.Item.Artists))
+	for i, artist := range player.Item.Artists {
+		artists[i] = artist.Name
+	}
+
+	// Images are sorted widest first
+	var albumImageURL string
+	if len(player.Item.Album.Images) > 0 {
+		albumImageURL = player.Item.Album.Images[0].URL
+	}
+
+	return domain.Track{
+		ID:            string(player.Item.ID),
+		Name:          player.Item.Name,
+		Artists:       artists,
+		Album:         player.Item.Album.Name,
+		AlbumImageURL: albumImageURL,
+		URL:           player.Item.ExternalURLs["spotify"],
+		Progress:      time.Duration(player.Progress) * time.Millisecond,
+		Duration:      time.Duration(player.Item.Duration) * time.Millisecond,
+	}
+}
+
+func shareTrackBlocks(slackUserID string, track domain.Track) []slack.Block {
+	trackText := fmt.Sprintf("*%s*\n%s\n_%s_", track.Name, strings.Join(track.Artists, ", "), track.Album)
+	if track.URL != "" {
+		trackText = fmt.Sprintf("*<%s|%s>*\n%s\n_%s_", track.URL, track.Name, strings.Join(track.Artists, ", "), track.Album)
+	}
+
+	var accessory *slack.Accessory
+	if track.AlbumImageURL != "" {
+		accessory = slack.NewAccessory(slack.NewImageBlockElement(track.AlbumImageURL, track.Album))
+	}
+
+	return []slack.Block{
+		slack.NewContextBlock("",
+			slack.NewTextBlockObject(slack.MarkdownType, fmt.Sprintf(":spotify: <@%s> is listening to", slackUserID), false, false),
+		),
+		slack.NewSectionBlock(
+			slack.NewTextBlockObject(slack.MarkdownType, trackText, false, false),
+			nil,
+			accessory,
+		),
+		slack.NewContextBlock("",
+			slack.NewTextBlockObject(slack.PlainTextType, progressText(track.Progress, track.Duration), false, false),
+		),
+	}
+}
+
+func progressText(progress, duration time.Duration) string {
+	filled := 0
+	if duration > 0 {
+		filled = int(progress * progressBarLength / duration)
+	}
+	if filled > progressBarLength {
+		filled = progressBarLength
+	}
+
+	bar := strings.Repeat("▬", filled) + "●" + strings.Repeat("▬", progressBarLength-filled)
+
+	return fmt.Sprintf("%s %s %s", formatTrackTime(progress), bar, formatTrackTime(duration))
+}
+
+func formatTrackTime(d time.Duration) string {
+	return fmt.Sprintf("%d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
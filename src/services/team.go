@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/o-mago/spotify-status/src/app_error"
+	"github.com/o-mago/spotify-status/src/crypto"
+	"github.com/o-mago/spotify-status/src/domain"
+	"github.com/slack-go/slack"
+	"github.com/zmb3/spotify"
+)
+
+// Extra time an observation stays valid after the track should have ended,
+// covering the poll interval and small pauses
+const nowPlayingGracePeriod = time.Minute
+
+func (s services) SearchTeamNowPlaying(ctx context.Context, slackTeamID string) ([]domain.User, error) {
+	workspace, err := s.repositories.SearchWorkspace(ctx, slackTeamID)
+	if err != nil && !errors.Is(err, app_error.WorkspaceNotFound) {
+		return []domain.User{}, err
+	}
+
+	// Workspaces without settings keep the team view allowed
+	if err == nil && !workspace.AllowTeamView {
+		return []domain.User{}, app_error.TeamViewDisabled
+	}
+
+	return s.repositories.SearchTeamNowPlaying(ctx, slackTeamID, time.Now())
+}
+
+func (s services) UpdateUserTeamVisibleBySlackID(ctx context.Context, user domain.User) error {
+	return s.repositories.UpdateUserTeamVisibleBySlackID(ctx, user)
+}
+
+func (s services) UpdateWorkspaceTeamView(ctx context.Context, workspace domain.Workspace) error {
+	return s.repositories.UpdateWorkspaceTeamView(ctx, workspace)
+}
+
+// IsWorkspaceAdmin asks Slack whether the user is an admin or an owner of the
+// workspace, with the bot token they installed the app with. Users who
+// haven't installed it, or did before the bot token was stored, can't be
+// checked and aren't admins.
+func (s services) IsWorkspaceAdmin(ctx context.Context, slackUserID, slackTeamID string) (bool, error) {
+	user, err := s.repositories.SearchUserBySlackID(ctx, slackUserID)
+	if errors.Is(err, app_error.UserNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if user.SlackBotAccessToken == "" {
+		return false, nil
+	}
+
+	botToken, err := s.crypto.Decrypt(user.SlackBotAccessToken, crypto.AssociatedData(user.ID, slackBotAccessTokenField))
+	if err != nil {
+		return false, err
+	}
+
+	info, err := newSlackClient(string(botToken)).GetUserInfoContext(ctx, slackUserID)
+	if err != nil {
+		return false, err
+	}
+
+	return info.TeamID == slackTeamID && (info.IsAdmin || info.IsOwner), nil
+}
+
+// backfillSlackTeamID stores the workspace of users who installed the app
+// before it was stored, asking Slack which one their token belongs to
+func (s services) backfillSlackTeamID(ctx context.Context, user domain.User, slackApi *slack.Client) (domain.User, error) {
+	auth, err := slackApi.AuthTestContext(ctx)
+	if err != nil {
+		return user, err
+	}
+
+	user.SlackTeamID = auth.TeamID
+
+	return user, s.repositories.UpdateUserSlackTeamIDBySlackID(ctx, user)
+}
+
+// updateNowPlaying stores what the poller observed, only writing when the
+// track changes, playback stops or the observation is about to expire
+func (s services) updateNowPlaying(ctx context.Context, user domain.User, player *spotify.CurrentlyPlaying) error {
+	now := time.Now()
+	playing := player != nil && player.Item != nil && player.Playing
+
+	if !playing {
+		if user.NowPlayingTrackID == "" {
+			return nil
+		}
+
+		return s.repositories.UpdateUserNowPlayingBySlackID(ctx, domain.User{SlackUserID: user.SlackUserID})
+	}
+
+	track := newTrackFromSpotify(player)
+	if track.ID == user.NowPlayingTrackID && user.NowPlayingUntil.After(now.Add(nowPlayingGracePeriod)) {
+		return nil
+	}
+
+	return s.repositories.UpdateUserNowPlayingBySlackID(ctx, domain.User{
+		SlackUserID:       user.SlackUserID,
+		NowPlayingTrackID: track.ID,
+		NowPlayingTrack:   track.Name,
+		NowPlayingArtists: strings.Join(track.Artists, ", "),
+		NowPlayingUntil:   now.Add(track.Duration - track.Progress + nowPlayingGracePeriod),
+	})
+}
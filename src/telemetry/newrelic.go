@@ -0,0 +1,93 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+type newRelic struct {
+	app *newrelic.Application
+}
+
+func NewNewRelic(appName, license string) (Telemetry, error) {
+	app, err := newrelic.NewApplication(
+		newrelic.ConfigAppName(appName),
+		newrelic.ConfigLicense(license),
+		newrelic.ConfigDistributedTracerEnabled(true),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return newRelic{app}, nil
+}
+
+func (t newRelic) Handler(name string, handler http.Handler) http.Handler {
+	_, wrapped := newrelic.WrapHandle(t.app, name, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r.WithContext(NewContext(r.Context(), t)))
+	}))
+
+	return wrapped
+}
+
+// StartSpan starts a segment when ctx has a transaction, on its own
+// goroutine handle as children often run concurrently, or a transaction
+// otherwise
+func (t newRelic) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	if txn := newrelic.FromContext(ctx); txn != nil {
+		txn = txn.NewGoroutine()
+
+		return newrelic.NewContext(ctx, txn), newRelicSegment{txn, txn.StartSegment(name)}
+	}
+
+	txn := t.app.StartTransaction(name)
+	ctx = newrelic.NewContext(NewContext(ctx, t), txn)
+
+	return ctx, newRelicTransaction{txn}
+}
+
+func (t newRelic) Shutdown(ctx context.Context) error {
+	timeout := 5 * time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+
+	t.app.Shutdown(timeout)
+	return nil
+}
+
+type newRelicTransaction struct {
+	txn *newrelic.Transaction
+}
+
+func (s newRelicTransaction) SetAttribute(key string, value interface{}) {
+	s.txn.AddAttribute(key, value)
+}
+
+func (s newRelicTransaction) RecordError(err error) {
+	s.txn.NoticeError(err)
+}
+
+func (s newRelicTransaction) End() {
+	s.txn.End()
+}
+
+type newRelicSegment struct {
+	txn     *newrelic.Transaction
+	segment *newrelic.Segment
+}
+
+func (s newRelicSegment) SetAttribute(key string, value interface{}) {
+	s.segment.AddAttribute(key, value)
+}
+
+func (s newRelicSegment) RecordError(err error) {
+	s.txn.NoticeError(err)
+}
+
+func (s newRelicSegment) End() {
+	s.segment.End()
+}
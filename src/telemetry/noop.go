@@ -0,0 +1,32 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+)
+
+type noop struct{}
+
+func NewNoop() Telemetry {
+	return noop{}
+}
+
+func (noop) Handler(name string, handler http.Handler) http.Handler {
+	return handler
+}
+
+func (noop) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+func (noop) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+
+func (noopSpan) RecordError(err error) {}
+
+func (noopSpan) End() {}
@@ -0,0 +1,106 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type otlp struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+}
+
+// NewOTLP exports spans over OTLP/HTTP to endpoint, or to where the
+// standard OTEL_EXPORTER_OTLP_* variables point when it's empty
+func NewOTLP(ctx context.Context, serviceName, endpoint string) (Telemetry, error) {
+	var options []otlptracehttp.Option
+	if endpoint != "" {
+		options = append(options, otlptracehttp.WithEndpointURL(endpoint))
+	}
+
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+	)
+
+	return otlp{provider, provider.Tracer("github.com/o-mago/spotify-status")}, nil
+}
+
+func (t otlp) Handler(name string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := t.tracer.Start(NewContext(r.Context(), t), name, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+
+		span.SetAttributes(semconv.HTTPRequestMethodKey.String(r.Method), semconv.HTTPRoute(name))
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(recorder, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(recorder.status))
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
+
+func (t otlp) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	ctx, span := t.tracer.Start(NewContext(ctx, t), name)
+
+	return ctx, otlpSpan{span}
+}
+
+func (t otlp) Shutdown(ctx context.Context) error {
+	return t.provider.Shutdown(ctx)
+}
+
+type otlpSpan struct {
+	span trace.Span
+}
+
+func (s otlpSpan) SetAttribute(key string, value interface{}) {
+	switch value := value.(type) {
+	case string:
+		s.span.SetAttributes(attribute.String(key, value))
+	case int:
+		s.span.SetAttributes(attribute.Int(key, value))
+	case int64:
+		s.span.SetAttributes(attribute.Int64(key, value))
+	case bool:
+		s.span.SetAttributes(attribute.Bool(key, value))
+	default:
+		s.span.SetAttributes(attribute.String(key, fmt.Sprint(value)))
+	}
+}
+
+func (s otlpSpan) RecordError(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+func (s otlpSpan) End() {
+	s.span.End()
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,46 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+)
+
+const (
+	NewRelic = "newrelic"
+	OTLP     = "otlp"
+	None     = "none"
+)
+
+// Telemetry records HTTP requests and background work as spans. A span
+// started on a context without a parent, like a cron tick, is a root
+// transaction; spans started under it are its children.
+type Telemetry interface {
+	Handler(name string, handler http.Handler) http.Handler
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+	Shutdown(ctx context.Context) error
+}
+
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+type contextKey struct{}
+
+// NewContext attaches telemetry to ctx so code without access to it can
+// start spans with StartSpan
+func NewContext(ctx context.Context, telemetry Telemetry) context.Context {
+	return context.WithValue(ctx, contextKey{}, telemetry)
+}
+
+// StartSpan starts a span with the telemetry attached to ctx, or a no-op
+// span if there is none
+func StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	telemetry, ok := ctx.Value(contextKey{}).(Telemetry)
+	if !ok {
+		return ctx, noopSpan{}
+	}
+
+	return telemetry.StartSpan(ctx, name)
+}